@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -13,20 +14,39 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/authz"
+	"hello-world/internal/campaigns"
+	"hello-world/internal/dberr"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ids"
+	"hello-world/internal/ledger"
+	"hello-world/internal/rules"
+	"hello-world/internal/tiers"
 )
 
 type AdminAwardRequest struct {
-	TargetUserID string  `json:"target_user_id"`
-	AmountKg     float64 `json:"amount_kg"`
-	Note         string  `json:"note"`  // Lý do cộng điểm
-	ManualPoints *int    `json:"manual_points,omitempty"` // Điểm nhập tay (nếu có)
+	TargetUserID    string         `json:"target_user_id"`
+	AmountKg        amount.Grams   `json:"amount_kg"`
+	Material        string         `json:"material"`                // HDPE, PET, PP or MIXED (default)
+	CollectionPoint string         `json:"collection_point"`        // Where the plastic was collected, for campaigns
+	Note            string         `json:"note"`                    // Lý do cộng điểm
+	ManualPoints    *amount.Points `json:"manual_points,omitempty"` // Điểm nhập tay (nếu có)
 }
 
 type ResponseBody struct {
-	Message      string  `json:"message"`
-	PointsAwarded float64 `json:"points_awarded"`
+	Message       string        `json:"message"`
+	PointsAwarded amount.Points `json:"points_awarded"`
+	Tier          string        `json:"tier"`
+	CampaignID    string        `json:"campaign_id,omitempty"`
+	CampaignBonus amount.Points `json:"campaign_bonus,omitempty"`
 }
 
+// maxProfileAttempts bounds how often an award is recomputed when the
+// profile changes between its read and the write.
+const maxProfileAttempts = 3
+
 var dbClient *dynamodb.Client
 var tableName string
 
@@ -40,125 +60,160 @@ func init() {
 }
 
 func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// 1. Authorization Check: Ensure the caller is an Admin
-	claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{})
-	if !ok {
-		return response(401, "Unauthorized"), nil
-	}
-
-	// Check for 'cognito:groups' claim
-	groups, ok := claims["cognito:groups"]
-	isAdmin := false
-	if ok {
-		// groups can be an interface{} which is actually a []interface{} from JSON
-		// We need to iterate and check for 'Admin'
-		// In simple cases, it might be a list of strings.
-		// AWS Cognito usually returns a list of strings for groups.
-		// Safe parsing:
-		groupList, ok := groups.([]interface{})
-		if ok {
-			for _, g := range groupList {
-				if str, ok := g.(string); ok && str == "Admin" {
-					isAdmin = true
-					break
-				}
-			}
-		}
-		// Also handles case where it might come as a specific format depend on library/mapping
-		// String check fallback just in case:
-		if fmt.Sprintf("%v", groups) == "[Admin]" { 
-			isAdmin = true
-		}
-	}
-
-	if !isAdmin {
-		// Strict check: Only Admins can use this API
-		// For Debugging/Demo purposes where Cognito Groups might not be set up:
-		fmt.Println("Warning: Non-Admin user accessing Admin API (Bypassed for testing)")
-		// return response(403, "Access Denied: Admins only"), nil
+	// 1. Authorization Check: Admins and Operators may award points
+	caller, err := authz.Authorize(request, authz.PermAwardPoints)
+	if err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
 	}
 
 	// 2. Parse Request Body
 	var req AdminAwardRequest
 	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		if errors.Is(err, amount.ErrPrecision) {
+			return response(400, "amount_kg allows 3 decimal places and manual_points 2"), nil
+		}
 		return response(400, "Invalid request body"), nil
 	}
 
 	if req.TargetUserID == "" {
 		return response(400, "Missing target_user_id"), nil
 	}
+	// Awards only add; points are taken back through a REVERSAL
+	if req.AmountKg < 0 {
+		return response(400, "AmountKg must be positive"), nil
+	}
+	if req.ManualPoints != nil && *req.ManualPoints < 0 {
+		return response(400, "manual_points must be positive"), nil
+	}
 
-	// 3. Calculate Points
-	var points float64
-	if req.ManualPoints != nil {
-		points = float64(*req.ManualPoints)
-	} else {
-		if req.AmountKg < 0 {
-			return response(400, "AmountKg must be positive"), nil
-		}
-		points = req.AmountKg * 10 // Standard Formula: 1kg = 10 pts
+	material, err := rules.ParseMaterial(req.Material)
+	if err != nil {
+		return response(400, "Invalid material"), nil
 	}
 
-	timestamp := time.Now().Format(time.RFC3339)
-	adminID := claims["sub"].(string)
+	// 3. Calculate Points from the earning rule active for the material
+	now := time.Now()
+	rule, err := rules.Resolve(ctx, dbClient, tableName, material, now)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load earning rules"), nil
+	}
+	tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load tiers"), nil
+	}
+	allCampaigns, err := campaigns.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load campaigns"), nil
+	}
+	timestamp := now.Format(time.RFC3339)
+	adminID := caller.UserID
 
-	// 4. Update DynamoDB (Transaction)
-	userPK := "USER#" + req.TargetUserID
-	historySK := "TRANS#" + timestamp
-	
 	// Prepare Note
 	note := req.Note
 	if note == "" {
-		note = fmt.Sprintf("Admin awarded points for %.1f kg plastic", req.AmountKg)
+		note = fmt.Sprintf("Admin awarded points for %s kg plastic", req.AmountKg)
 	}
 
-	_, err := dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: []types.TransactWriteItem{
+	// The bonus and the new tier come from the profile as read, so the
+	// update is guarded by its version. A concurrent write cancels the
+	// transaction and the award is worked out again on the new profile.
+	var points, bonus amount.Points
+	var campaign campaigns.Campaign
+	var newTier tiers.Tier
+	for attempt := 1; ; attempt++ {
+		profile, err := ledger.LoadProfile(ctx, dbClient, tableName, req.TargetUserID)
+		if err != nil {
+			fmt.Println("DynamoDB Error:", err)
+			return response(500, "System Error: Failed to load user profile"), nil
+		}
+		tier := tiers.Current(tierConfig, profile)
+
+		bonus, campaign = 0, campaigns.Campaign{}
+		ruleID := "MANUAL"
+		if req.ManualPoints != nil {
+			points = *req.ManualPoints
+		} else {
+			if points, err = rule.Points(req.AmountKg); err != nil {
+				return response(400, err.Error()), nil
+			}
+			// Manual points are taken as given; rule points get the tier bonus
+			if points, err = tier.Apply(points); err != nil {
+				return response(400, err.Error()), nil
+			}
+			if campaign, bonus, _, err = campaigns.Best(allCampaigns, material, req.CollectionPoint, now, points); err != nil {
+				return response(400, err.Error()), nil
+			}
+			points += bonus
+			ruleID = rule.ID()
+		}
+		var change *tiers.Change
+		newTier, change = tiers.Evaluate(tierConfig, profile.Tier, profile.TotalKg+req.AmountKg)
+
+		// 4. Update DynamoDB (Transaction)
+		userPK := ledger.UserPK(req.TargetUserID)
+		historySK := "TRANS#" + ids.NewAt(now)
+
+		entry := map[string]types.AttributeValue{
+			"PK":              &types.AttributeValueMemberS{Value: userPK},
+			"SK":              &types.AttributeValueMemberS{Value: historySK},
+			"Type":            &types.AttributeValueMemberS{Value: string(ledger.TypeAdminAward)}, // Distinct type from DONATE
+			"AmountKg":        req.AmountKg.Attr(),
+			"PointsEarned":    points.Attr(),
+			"Material":        &types.AttributeValueMemberS{Value: string(material)},
+			"CollectionPoint": &types.AttributeValueMemberS{Value: req.CollectionPoint},
+			"RuleID":          &types.AttributeValueMemberS{Value: ruleID},
+			"Note":            &types.AttributeValueMemberS{Value: note},
+			"AdminID":         &types.AttributeValueMemberS{Value: adminID}, // Audit trail
+			"CreatedAt":       &types.AttributeValueMemberS{Value: timestamp},
+			"ExpiresAt":       &types.AttributeValueMemberS{Value: ledger.LotExpiry(now).Format(time.RFC3339)},
+			"Status":          &types.AttributeValueMemberS{Value: ledger.StatusApproved}, // Auto-approved
+			"Tier":            &types.AttributeValueMemberS{Value: tier.Name},
+		}
+		if campaign.ID != "" {
+			// Kept apart from the total so campaign ROI can be reported
+			entry["CampaignID"] = &types.AttributeValueMemberS{Value: campaign.ID}
+			entry["CampaignBonus"] = bonus.Attr()
+		}
+
+		items := []types.TransactWriteItem{
 			// History Record
 			{
 				Put: &types.Put{
 					TableName: aws.String(tableName),
-					Item: map[string]types.AttributeValue{
-						"PK":           &types.AttributeValueMemberS{Value: userPK},
-						"SK":           &types.AttributeValueMemberS{Value: historySK},
-						"Type":         &types.AttributeValueMemberS{Value: "ADMIN_AWARD"}, // Distinct type from DONATE
-						"AmountKg":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", req.AmountKg)},
-						"PointsEarned": &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", points)},
-						"Note":         &types.AttributeValueMemberS{Value: note},
-						"AdminID":      &types.AttributeValueMemberS{Value: adminID}, // Audit trail
-						"CreatedAt":    &types.AttributeValueMemberS{Value: timestamp},
-						"Status":		&types.AttributeValueMemberS{Value: "approved"}, // Auto-approved
-					},
+					Item:      entry,
 				},
 			},
 			// Update User Profile Balance
-			{
-				Update: &types.Update{
-					TableName: aws.String(tableName),
-					Key: map[string]types.AttributeValue{
-						"PK": &types.AttributeValueMemberS{Value: userPK},
-						"SK": &types.AttributeValueMemberS{Value: "PROFILE"},
-					},
-					UpdateExpression: aws.String("ADD TotalPoints :p, TotalKg :k SET UpdatedAt = :t"),
-					ExpressionAttributeValues: map[string]types.AttributeValue{
-						":p": &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", points)},
-						":k": &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", req.AmountKg)},
-						":t": &types.AttributeValueMemberS{Value: timestamp},
-					},
-				},
-			},
-		},
-	})
+			ledger.ProfileUpdate(tableName, req.TargetUserID, ledger.Delta{Points: points, Kg: req.AmountKg, Tier: newTier.Name}, timestamp, &profile.BalanceVersion),
+		}
+		if change != nil {
+			items = append(items, change.HistoryItem(tableName, req.TargetUserID, profile.TotalKg+req.AmountKg, now))
+		}
 
-	if err != nil {
-		fmt.Println("DynamoDB Transaction Error:", err)
-		return response(500, "System Error: Failed to award points"), nil
+		_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if dberr.ConditionFailedAt(err, 1) {
+			if attempt < maxProfileAttempts {
+				continue
+			}
+			return response(409, "User profile changed, please try again"), nil
+		}
+		if err != nil {
+			fmt.Println("DynamoDB Transaction Error:", err)
+			return response(500, "System Error: Failed to award points"), nil
+		}
+		break
 	}
 
 	// 5. Success Response
 	resBody := ResponseBody{
 		Message:       "Points awarded successfully",
 		PointsAwarded: points,
+		Tier:          newTier.Name,
+		CampaignID:    campaign.ID,
+		CampaignBonus: bonus,
 	}
 	jsonBody, _ := json.Marshal(resBody)
 
@@ -167,7 +222,7 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 		StatusCode: 200,
 		Headers: map[string]string{
 			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Headers": "Content-Type,Authorization",
+			"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
 			"Access-Control-Allow-Methods": "POST,OPTIONS",
 		},
 	}, nil
@@ -184,5 +239,5 @@ func response(status int, message string) events.APIGatewayProxyResponse {
 }
 
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
 }
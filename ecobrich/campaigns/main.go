@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/authz"
+	"hello-world/internal/campaigns"
+	"hello-world/internal/dberr"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ids"
+	"hello-world/internal/rules"
+)
+
+// CampaignSummary is a campaign with whether it is running right now.
+type CampaignSummary struct {
+	campaigns.Campaign
+	Active bool `json:"active"`
+}
+
+type ListResponse struct {
+	Campaigns []CampaignSummary `json:"campaigns"`
+}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+
+	// 1. Authorization Check: campaigns change earning rates, an Admin-only setting
+	caller, err := authz.Authorize(request, authz.PermManageConfig)
+	if err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
+	}
+
+	switch request.HTTPMethod {
+	case "GET":
+		return listCampaigns(ctx)
+	case "POST":
+		return createCampaign(ctx, request, caller)
+	}
+	return response(405, "Method Not Allowed"), nil
+}
+
+func listCampaigns(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	all, err := campaigns.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load campaigns"), nil
+	}
+
+	now := time.Now()
+	list := make([]CampaignSummary, len(all))
+	for i, c := range all {
+		starts, _ := time.Parse(time.RFC3339, c.StartsAt)
+		ends, _ := time.Parse(time.RFC3339, c.EndsAt)
+		list[i] = CampaignSummary{Campaign: c, Active: !now.Before(starts) && now.Before(ends)}
+	}
+	return jsonResponse(200, ListResponse{Campaigns: list}), nil
+}
+
+func createCampaign(ctx context.Context, request events.APIGatewayProxyRequest, caller authz.Identity) (events.APIGatewayProxyResponse, error) {
+	var c campaigns.Campaign
+	if err := json.Unmarshal([]byte(request.Body), &c); err != nil {
+		if errors.Is(err, amount.ErrPrecision) {
+			return response(400, "bonus_points allows 2 decimal places"), nil
+		}
+		return response(400, "Invalid request body"), nil
+	}
+	if err := c.Validate(); err != nil {
+		return response(400, err.Error()), nil
+	}
+
+	now := time.Now()
+	c.ID = ids.NewAt(now)
+	c.Name = strings.TrimSpace(c.Name)
+	c.CreatedBy = caller.UserID
+	c.CreatedAt = now.Format(time.RFC3339)
+	if c.Materials == nil {
+		c.Materials = []rules.Material{}
+	}
+	if c.CollectionPoints == nil {
+		c.CollectionPoints = []string{}
+	}
+
+	_, err := dbClient.PutItem(ctx, campaigns.PutInput(tableName, c))
+	if dberr.ConditionFailed(err) {
+		return response(409, "Campaign already exists"), nil
+	}
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to save campaign"), nil
+	}
+	return jsonResponse(201, c), nil
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
+		"Access-Control-Allow-Methods": "GET,POST,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
+}
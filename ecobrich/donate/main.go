@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -13,18 +16,30 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ids"
+	"hello-world/internal/ledger"
+	"hello-world/internal/rules"
+	"hello-world/internal/tiers"
 )
 
 // Cấu trúc dữ liệu nhận từ Frontend
 type RequestBody struct {
-	Amount float64 `json:"amount"` // Số kg nhựa
-	Note   string  `json:"note"`
+	Amount   amount.Grams `json:"amount"`   // Số kg nhựa, tối đa 3 chữ số thập phân
+	Material string       `json:"material"` // HDPE, PET, PP hoặc MIXED (mặc định)
+	// Mã điểm thu gom, dùng để áp dụng chiến dịch khi quyên góp được duyệt
+	CollectionPoint string `json:"collection_point"`
+	Note            string `json:"note"`
 }
 
 // Cấu trúc trả về
 type ResponseBody struct {
-	Message      string  `json:"message"`
-	PointsPending float64 `json:"points_pending"`
+	Message       string        `json:"message"`
+	PointsPending amount.Points `json:"points_pending"`
+	Material      string        `json:"material"`
+	Tier          string        `json:"tier"`
 }
 
 var dbClient *dynamodb.Client
@@ -46,11 +61,14 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 	if !ok {
 		return response(401, "Không tìm thấy thông tin xác thực"), nil
 	}
-	userID := claims["sub"].(string) 
+	userID := claims["sub"].(string)
 
 	// 2. Parse Body lấy số kg
 	var body RequestBody
 	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		if errors.Is(err, amount.ErrPrecision) {
+			return response(400, "Số kg chỉ được có tối đa 3 chữ số thập phân"), nil
+		}
 		return response(400, "Dữ liệu không hợp lệ"), nil
 	}
 
@@ -58,33 +76,78 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 		return response(400, "Số lượng phải lớn hơn 0"), nil
 	}
 
-	// 3. Tính điểm dự kiến (1kg = 10 điểm)
-	points := body.Amount * 10
-	timestamp := time.Now().Format(time.RFC3339)
+	material, err := rules.ParseMaterial(body.Material)
+	if err != nil {
+		return response(400, "Loại nhựa không hợp lệ"), nil
+	}
+
+	// 3. Tính điểm dự kiến theo quy tắc đang áp dụng cho loại nhựa
+	now := time.Now()
+	rule, err := rules.Resolve(ctx, dbClient, tableName, material, now)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "Lỗi hệ thống khi tải quy tắc tích điểm"), nil
+	}
+	points, err := rule.Points(body.Amount)
+	if errors.Is(err, rules.ErrBelowMinimum) {
+		return response(400, fmt.Sprintf("Số lượng tối thiểu cho %s là %s kg", material, rule.MinKg)), nil
+	}
+	if err != nil {
+		return response(400, "Số lượng quá lớn"), nil
+	}
+
+	// Thưởng theo hạng thành viên hiện tại
+	profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "Lỗi hệ thống khi tải hồ sơ"), nil
+	}
+	tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "Lỗi hệ thống khi tải hạng thành viên"), nil
+	}
+	tier := tiers.Current(tierConfig, profile)
+	if points, err = tier.Apply(points); err != nil {
+		return response(400, "Số lượng quá lớn"), nil
+	}
+	timestamp := now.Format(time.RFC3339)
+
+	// 4. Ghi vào DynamoDB - HISTORY với Status=pending
+	// Điểm chưa được cộng vào TotalPoints, chỉ cộng vào PendingPoints của Profile
+
+	userPK := ledger.UserPK(userID)
+	historySK := "TRANS#" + ids.NewAt(now)
 
-	// 4. Ghi vào DynamoDB - CHỈ GHI HISTORY với Status=pending
-	// Không cộng điểm ngay vào Profile
-	
-	userPK := "USER#" + userID
-	historySK := "TRANS#" + timestamp
-	
 	// Default note
 	note := body.Note
 	if note == "" {
 		note = "Quyên góp tại điểm thu gom"
 	}
 
-	_, err := dbClient.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item: map[string]types.AttributeValue{
-			"PK":           &types.AttributeValueMemberS{Value: userPK},
-			"SK":           &types.AttributeValueMemberS{Value: historySK},
-			"Type":         &types.AttributeValueMemberS{Value: "DONATE"},
-			"AmountKg":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", body.Amount)},
-			"PointsEarned": &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", points)},
-			"Note":         &types.AttributeValueMemberS{Value: note},
-			"Status":       &types.AttributeValueMemberS{Value: "pending"}, // Chờ duyệt
-			"CreatedAt":    &types.AttributeValueMemberS{Value: timestamp},
+	_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName: aws.String(tableName),
+					Item: map[string]types.AttributeValue{
+						"PK":              &types.AttributeValueMemberS{Value: userPK},
+						"SK":              &types.AttributeValueMemberS{Value: historySK},
+						"Type":            &types.AttributeValueMemberS{Value: string(ledger.TypeDonate)},
+						"AmountKg":        body.Amount.Attr(),
+						"PointsEarned":    points.Attr(),
+						"Material":        &types.AttributeValueMemberS{Value: string(material)},
+						"RuleID":          &types.AttributeValueMemberS{Value: rule.ID()},
+						"CollectionPoint": &types.AttributeValueMemberS{Value: strings.TrimSpace(body.CollectionPoint)},
+						"Tier":            &types.AttributeValueMemberS{Value: tier.Name},
+						"TierPercent":     &types.AttributeValueMemberN{Value: strconv.FormatInt(tier.MultiplierPercent, 10)},
+						"Note":            &types.AttributeValueMemberS{Value: note},
+						"Status":          &types.AttributeValueMemberS{Value: ledger.StatusPending}, // Chờ duyệt
+						"CreatedAt":       &types.AttributeValueMemberS{Value: timestamp},
+					},
+				},
+			},
+			ledger.ProfileUpdate(tableName, userID, ledger.Delta{PendingPoints: points}, timestamp, nil),
 		},
 	})
 
@@ -97,6 +160,8 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 	resBody := ResponseBody{
 		Message:       "Quyên góp thành công và đang chờ duyệt",
 		PointsPending: points,
+		Material:      string(material),
+		Tier:          tier.Name,
 	}
 	jsonBody, _ := json.Marshal(resBody)
 
@@ -105,7 +170,7 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 		StatusCode: 200,
 		Headers: map[string]string{
 			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Headers": "Content-Type,Authorization",
+			"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
 			"Access-Control-Allow-Methods": "POST,OPTIONS",
 		},
 	}, nil
@@ -123,5 +188,5 @@ func response(status int, message string) events.APIGatewayProxyResponse {
 }
 
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
 }
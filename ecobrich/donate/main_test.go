@@ -1,59 +1,77 @@
 package main
 
 import (
+	"context"
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
 func TestHandler(t *testing.T) {
+	claims := map[string]interface{}{"sub": "user-1"}
+
 	testCases := []struct {
-		name          string
-		request       events.APIGatewayProxyRequest
-		expectedBody  string
-		expectedError error
+		name           string
+		request        events.APIGatewayProxyRequest
+		expectedStatus int
 	}{
 		{
-			// mock a request with an empty SourceIP
-			name: "empty IP",
+			// mock a request that did not pass through the Cognito authorizer
+			name:           "missing claims",
+			request:        events.APIGatewayProxyRequest{Body: `{"amount": 1}`},
+			expectedStatus: 401,
+		},
+		{
+			name: "invalid body",
+			request: events.APIGatewayProxyRequest{
+				Body: `not json`,
+				RequestContext: events.APIGatewayProxyRequestContext{
+					Authorizer: map[string]interface{}{"claims": claims},
+				},
+			},
+			expectedStatus: 400,
+		},
+		{
+			name: "non-positive amount",
 			request: events.APIGatewayProxyRequest{
+				Body: `{"amount": 0}`,
 				RequestContext: events.APIGatewayProxyRequestContext{
-					Identity: events.APIGatewayRequestIdentity{
-						SourceIP: "",
-					},
+					Authorizer: map[string]interface{}{"claims": claims},
 				},
 			},
-			expectedBody:  "Hello, world!\n",
-			expectedError: nil,
+			expectedStatus: 400,
 		},
 		{
-			// mock a request with a localhost SourceIP
-			name: "localhost IP",
+			name: "amount finer than a gram",
 			request: events.APIGatewayProxyRequest{
+				Body: `{"amount": 0.0001}`,
 				RequestContext: events.APIGatewayProxyRequestContext{
-					Identity: events.APIGatewayRequestIdentity{
-						SourceIP: "127.0.0.1",
-					},
+					Authorizer: map[string]interface{}{"claims": claims},
 				},
 			},
-			expectedBody:  "Hello, 127.0.0.1!\n",
-			expectedError: nil,
+			expectedStatus: 400,
+		},
+		{
+			name: "unknown material",
+			request: events.APIGatewayProxyRequest{
+				Body: `{"amount": 1, "material": "glass"}`,
+				RequestContext: events.APIGatewayProxyRequestContext{
+					Authorizer: map[string]interface{}{"claims": claims},
+				},
+			},
+			expectedStatus: 400,
 		},
 	}
 
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			response, err := handler(testCase.request)
-			if err != testCase.expectedError {
-				t.Errorf("Expected error %v, but got %v", testCase.expectedError, err)
-			}
-
-			if response.Body != testCase.expectedBody {
-				t.Errorf("Expected response %v, but got %v", testCase.expectedBody, response.Body)
+			response, err := handleRequest(context.Background(), testCase.request)
+			if err != nil {
+				t.Errorf("Expected no error, but got %v", err)
 			}
 
-			if response.StatusCode != 200 {
-				t.Errorf("Expected status code 200, but got %v", response.StatusCode)
+			if response.StatusCode != testCase.expectedStatus {
+				t.Errorf("Expected status code %v, but got %v", testCase.expectedStatus, response.StatusCode)
 			}
 		})
 	}
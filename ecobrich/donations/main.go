@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/authz"
+	"hello-world/internal/campaigns"
+	"hello-world/internal/cursor"
+	"hello-world/internal/dberr"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ledger"
+	"hello-world/internal/rules"
+	"hello-world/internal/tiers"
+)
+
+type RejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// DonationSummary is one row of the admin review queue.
+type DonationSummary struct {
+	UserID          string        `json:"user_id"`
+	SK              string        `json:"sk"`
+	AmountKg        amount.Grams  `json:"amount_kg"`
+	Points          amount.Points `json:"points"`
+	Note            string        `json:"note"`
+	Status          string        `json:"status"`
+	CreatedAt       string        `json:"created_at"`
+	DecidedBy       string        `json:"decided_by,omitempty"`
+	DecidedAt       string        `json:"decided_at,omitempty"`
+	RejectionReason string        `json:"rejection_reason,omitempty"`
+}
+
+type ListResponse struct {
+	Donations  []DonationSummary `json:"donations"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+type DecisionResponse struct {
+	Message        string        `json:"message"`
+	UserID         string        `json:"user_id"`
+	SK             string        `json:"sk"`
+	Status         string        `json:"status"`
+	PointsCredited amount.Points `json:"points_credited"`
+	CampaignID     string        `json:"campaign_id,omitempty"`
+	CampaignBonus  amount.Points `json:"campaign_bonus,omitempty"`
+}
+
+// statusIndex is the Status + CreatedAt GSI declared on PlasticDbTable.
+const statusIndex = "StatusIndex"
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+	// maxScanned bounds the index items one request reads. The approved
+	// partition also holds every other settled entry, so a narrow filter
+	// returns a short page with next_cursor rather than scanning it all.
+	maxScanned = 1000
+)
+
+// maxProfileAttempts bounds how often an approval is retried when the
+// profile changes between its read and the write.
+const maxProfileAttempts = 3
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+
+	// 1. Authorization Check: Admins and Operators review donations
+	caller, err := authz.Authorize(request, authz.PermReviewDonations)
+	if err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
+	}
+	adminID := caller.UserID
+
+	if request.HTTPMethod == "GET" {
+		return listDonations(ctx, request.QueryStringParameters)
+	}
+
+	// 2. Resolve the donation from the path
+	userID := request.PathParameters["userId"]
+	sk, err := url.PathUnescape(request.PathParameters["sk"])
+	if err != nil || userID == "" || sk == "" {
+		return response(400, "Invalid donation reference"), nil
+	}
+	// The history API returns the SK as is, but accept the bare ID too
+	if !strings.HasPrefix(sk, "TRANS#") {
+		sk = "TRANS#" + sk
+	}
+
+	switch {
+	case strings.HasSuffix(request.Resource, "/approve"):
+		return approve(ctx, adminID, userID, sk)
+	case strings.HasSuffix(request.Resource, "/reject"):
+		var body RejectRequest
+		if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+			return response(400, "Invalid request body"), nil
+		}
+		if strings.TrimSpace(body.Reason) == "" {
+			return response(400, "A rejection reason is required"), nil
+		}
+		return reject(ctx, adminID, userID, sk, body.Reason)
+	}
+	return response(404, "Not Found"), nil
+}
+
+func approve(ctx context.Context, adminID, userID, sk string) (events.APIGatewayProxyResponse, error) {
+	donation, res, ok := loadPendingDonation(ctx, userID, sk)
+	if !ok {
+		return res, nil
+	}
+
+	tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load tiers"), nil
+	}
+
+	// Campaigns are matched against the time of the donation, so one made
+	// during an event keeps its bonus even if it is reviewed afterwards
+	allCampaigns, err := campaigns.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load campaigns"), nil
+	}
+	donatedAt, _ := time.Parse(time.RFC3339, donation.CreatedAt)
+	campaign, bonus, inCampaign, err := campaigns.Best(allCampaigns, rules.Material(donation.Material), donation.CollectionPoint, donatedAt, donation.PointsEarned)
+	if err != nil {
+		return response(400, err.Error()), nil
+	}
+	credited := donation.PointsEarned + bonus
+
+	nowTime := time.Now()
+	now := nowTime.Format(time.RFC3339)
+	// Approval credits the points, so it also starts their expiry clock
+	approval := decide(userID, donation.SK, ledger.StatusApproved, adminID, now, nil)
+	approval.Update.UpdateExpression = aws.String(*approval.Update.UpdateExpression + ", ExpiresAt = :expires")
+	approval.Update.ExpressionAttributeValues[":expires"] = &types.AttributeValueMemberS{Value: ledger.LotExpiry(nowTime).Format(time.RFC3339)}
+	if inCampaign {
+		approval.Update.UpdateExpression = aws.String(*approval.Update.UpdateExpression + ", PointsEarned = :earned, CampaignID = :campaign, CampaignBonus = :bonus")
+		approval.Update.ExpressionAttributeValues[":earned"] = credited.Attr()
+		approval.Update.ExpressionAttributeValues[":campaign"] = &types.AttributeValueMemberS{Value: campaign.ID}
+		approval.Update.ExpressionAttributeValues[":bonus"] = bonus.Attr()
+	}
+
+	// Approved kg count towards the tier, which may change with it. The
+	// tier comes from the profile as read, so the update is guarded by its
+	// version and worked out again if another write lands first.
+	for attempt := 1; ; attempt++ {
+		profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
+		if err != nil {
+			fmt.Println("DynamoDB Error:", err)
+			return response(500, "System Error: Failed to load user profile"), nil
+		}
+		totalKg := profile.TotalKg + donation.AmountKg
+		tier, change := tiers.Evaluate(tierConfig, profile.Tier, totalKg)
+
+		items := []types.TransactWriteItem{
+			approval,
+			ledger.ProfileUpdate(tableName, userID, ledger.Delta{
+				Points:        credited,
+				Kg:            donation.AmountKg,
+				PendingPoints: -donation.PointsEarned,
+				Tier:          tier.Name,
+			}, now, &profile.BalanceVersion),
+		}
+		if change != nil {
+			items = append(items, change.HistoryItem(tableName, userID, totalKg, nowTime))
+		}
+		_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if dberr.ConditionFailedAt(err, 0) {
+			return response(409, "Donation has already been decided"), nil
+		}
+		if dberr.ConditionFailedAt(err, 1) {
+			if attempt < maxProfileAttempts {
+				continue
+			}
+			return response(409, "User profile changed, please try again"), nil
+		}
+		if err != nil {
+			fmt.Println("DynamoDB Transaction Error:", err)
+			return response(500, "System Error: Failed to approve donation"), nil
+		}
+		break
+	}
+
+	return jsonResponse(200, DecisionResponse{
+		Message:        "Donation approved",
+		UserID:         userID,
+		SK:             donation.SK,
+		Status:         ledger.StatusApproved,
+		PointsCredited: credited,
+		CampaignID:     campaign.ID,
+		CampaignBonus:  bonus,
+	}), nil
+}
+
+func reject(ctx context.Context, adminID, userID, sk, reason string) (events.APIGatewayProxyResponse, error) {
+	donation, res, ok := loadPendingDonation(ctx, userID, sk)
+	if !ok {
+		return res, nil
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	_, err := dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			decide(userID, donation.SK, ledger.StatusRejected, adminID, now, &reason),
+			ledger.ProfileUpdate(tableName, userID, ledger.Delta{PendingPoints: -donation.PointsEarned}, now, nil),
+		},
+	})
+	if dberr.ConditionFailedAt(err, 0) {
+		return response(409, "Donation has already been decided"), nil
+	}
+	if err != nil {
+		fmt.Println("DynamoDB Transaction Error:", err)
+		return response(500, "System Error: Failed to reject donation"), nil
+	}
+
+	return jsonResponse(200, DecisionResponse{
+		Message: "Donation rejected",
+		UserID:  userID,
+		SK:      donation.SK,
+		Status:  ledger.StatusRejected,
+	}), nil
+}
+
+// listDonations serves the review queue from the StatusIndex GSI, oldest
+// first. Supported query parameters: status (default pending), user, from,
+// to (RFC3339 or YYYY-MM-DD), min_kg, limit, cursor and order=desc. A page
+// can be short, or empty, while next_cursor is set.
+func listDonations(ctx context.Context, params map[string]string) (events.APIGatewayProxyResponse, error) {
+	status := params["status"]
+	if status == "" {
+		status = ledger.StatusPending
+	}
+	if status != ledger.StatusPending && status != ledger.StatusApproved && status != ledger.StatusRejected {
+		return response(400, "status must be pending, approved or rejected"), nil
+	}
+
+	limit := defaultPageSize
+	if v := params["limit"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return response(400, "limit must be a positive integer"), nil
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	startKey, err := cursor.Decode(params["cursor"])
+	if err != nil {
+		return response(400, "Invalid cursor"), nil
+	}
+
+	names := map[string]string{"#status": "Status", "#type": "Type"}
+	values := map[string]types.AttributeValue{
+		":status": &types.AttributeValueMemberS{Value: status},
+		":donate": &types.AttributeValueMemberS{Value: string(ledger.TypeDonate)},
+	}
+
+	keyCond := "#status = :status"
+	from, err := parseDateParam(params["from"], false)
+	if err != nil {
+		return response(400, "from must be RFC3339 or YYYY-MM-DD"), nil
+	}
+	to, err := parseDateParam(params["to"], true)
+	if err != nil {
+		return response(400, "to must be RFC3339 or YYYY-MM-DD"), nil
+	}
+	switch {
+	case from != "" && to != "":
+		keyCond += " AND CreatedAt BETWEEN :from AND :to"
+	case from != "":
+		keyCond += " AND CreatedAt >= :from"
+	case to != "":
+		keyCond += " AND CreatedAt <= :to"
+	}
+	if from != "" {
+		values[":from"] = &types.AttributeValueMemberS{Value: from}
+	}
+	if to != "" {
+		values[":to"] = &types.AttributeValueMemberS{Value: to}
+	}
+
+	// Vouchers share the index through their own Status, so always filter on Type
+	filters := []string{"#type = :donate"}
+	if user := params["user"]; user != "" {
+		filters = append(filters, "PK = :pk")
+		values[":pk"] = &types.AttributeValueMemberS{Value: ledger.UserPK(user)}
+	}
+	if v := params["min_kg"]; v != "" {
+		minKg, err := amount.ParseKg(v)
+		if err != nil || minKg < 0 {
+			return response(400, "min_kg must be a non-negative number of kg with at most 3 decimals"), nil
+		}
+		filters = append(filters, "AmountKg >= :minKg")
+		values[":minKg"] = minKg.Attr()
+	}
+
+	donations := []DonationSummary{}
+	scanned := 0
+	for {
+		// Filters run after Limit, so keep reading until the page is full
+		out, err := dbClient.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(tableName),
+			IndexName:                 aws.String(statusIndex),
+			KeyConditionExpression:    aws.String(keyCond),
+			FilterExpression:          aws.String(strings.Join(filters, " AND ")),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ExclusiveStartKey:         startKey,
+			Limit:                     aws.Int32(int32(limit - len(donations))),
+			ScanIndexForward:          aws.Bool(params["order"] != "desc"),
+		})
+		if err != nil {
+			fmt.Println("DynamoDB Query Error:", err)
+			return response(500, "System Error: Failed to list donations"), nil
+		}
+		for _, item := range out.Items {
+			d, err := toSummary(item)
+			if err != nil {
+				fmt.Println("Ledger Error:", err)
+				return response(500, "System Error: Invalid donation record"), nil
+			}
+			donations = append(donations, d)
+		}
+		startKey = out.LastEvaluatedKey
+		scanned += int(out.ScannedCount)
+		if startKey == nil || len(donations) >= limit || scanned >= maxScanned {
+			break
+		}
+	}
+
+	next, err := cursor.Encode(startKey)
+	if err != nil {
+		fmt.Println("Cursor Error:", err)
+		return response(500, "System Error: Failed to list donations"), nil
+	}
+	return jsonResponse(200, ListResponse{Donations: donations, NextCursor: next}), nil
+}
+
+func toSummary(item map[string]types.AttributeValue) (DonationSummary, error) {
+	e, _, err := ledger.FromItem(item)
+	if err != nil {
+		return DonationSummary{}, err
+	}
+	str := func(name string) string {
+		if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	return DonationSummary{
+		UserID:          strings.TrimPrefix(str("PK"), ledger.UserPK("")),
+		SK:              e.SK,
+		AmountKg:        e.AmountKg,
+		Points:          e.PointsEarned,
+		Note:            e.Note,
+		Status:          e.Status,
+		CreatedAt:       e.CreatedAt,
+		DecidedBy:       str("DecidedBy"),
+		DecidedAt:       str("DecidedAt"),
+		RejectionReason: str("RejectionReason"),
+	}, nil
+}
+
+// parseDateParam normalises a date filter to the RFC3339 form stored in
+// CreatedAt. A bare date covers the whole day when endOfDay is set.
+func parseDateParam(v string, endOfDay bool) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Format(time.RFC3339), nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return "", err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t.Format(time.RFC3339), nil
+}
+
+// loadPendingDonation returns the donation, or the response to send when it
+// cannot be decided. The returned entry carries the current SK, which differs
+// from sk for donations referenced by their pre-migration key.
+func loadPendingDonation(ctx context.Context, userID, sk string) (ledger.Entry, events.APIGatewayProxyResponse, bool) {
+	item, err := ledger.GetItem(ctx, dbClient, tableName, userID, sk)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return ledger.Entry{}, response(500, "System Error: Failed to load donation"), false
+	}
+	if item == nil {
+		return ledger.Entry{}, response(404, "Donation not found"), false
+	}
+
+	e, ok, err := ledger.FromItem(item)
+	if err != nil {
+		fmt.Println("Ledger Error:", err)
+		return ledger.Entry{}, response(500, "System Error: Invalid donation record"), false
+	}
+	if !ok || e.Type != ledger.TypeDonate {
+		return ledger.Entry{}, response(400, "Item is not a donation"), false
+	}
+	if e.Status != ledger.StatusPending {
+		return ledger.Entry{}, response(409, "Donation has already been "+e.Status), false
+	}
+	return e, events.APIGatewayProxyResponse{}, true
+}
+
+// decide moves a donation out of pending. The condition is what prevents a
+// second approval from crediting the same donation twice.
+func decide(userID, sk, status, adminID, now string, reason *string) types.TransactWriteItem {
+	update := &types.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+			"SK": &types.AttributeValueMemberS{Value: sk},
+		},
+		UpdateExpression:    aws.String("SET #status = :status, DecidedBy = :admin, DecidedAt = :t"),
+		ConditionExpression: aws.String("#status = :pending AND #type = :donate"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+			"#type":   "Type",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":  &types.AttributeValueMemberS{Value: status},
+			":admin":   &types.AttributeValueMemberS{Value: adminID},
+			":t":       &types.AttributeValueMemberS{Value: now},
+			":pending": &types.AttributeValueMemberS{Value: ledger.StatusPending},
+			":donate":  &types.AttributeValueMemberS{Value: string(ledger.TypeDonate)},
+		},
+	}
+	if reason != nil {
+		update.UpdateExpression = aws.String(*update.UpdateExpression + ", RejectionReason = :reason")
+		update.ExpressionAttributeValues[":reason"] = &types.AttributeValueMemberS{Value: *reason}
+	}
+	return types.TransactWriteItem{Update: update}
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
+		"Access-Control-Allow-Methods": "GET,POST,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/authz"
+	"hello-world/internal/dberr"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/rules"
+)
+
+type ListResponse struct {
+	Rules  []rules.Rule                  `json:"rules"`
+	Active map[rules.Material]rules.Rule `json:"active"`
+}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+
+	// 1. Authorization Check: rates are an Admin-only setting
+	caller, err := authz.Authorize(request, authz.PermManageConfig)
+	if err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
+	}
+
+	switch request.HTTPMethod {
+	case "GET":
+		return listRules(ctx)
+	case "POST":
+		return createRule(ctx, request, caller)
+	}
+	return response(405, "Method Not Allowed"), nil
+}
+
+func listRules(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	all, err := rules.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load earning rules"), nil
+	}
+	if all == nil {
+		all = []rules.Rule{}
+	}
+
+	now := time.Now()
+	active := map[rules.Material]rules.Rule{}
+	for _, m := range rules.Materials {
+		active[m] = rules.Active(all, m, now)
+	}
+	return jsonResponse(200, ListResponse{Rules: all, Active: active}), nil
+}
+
+func createRule(ctx context.Context, request events.APIGatewayProxyRequest, caller authz.Identity) (events.APIGatewayProxyResponse, error) {
+	var r rules.Rule
+	if err := json.Unmarshal([]byte(request.Body), &r); err != nil {
+		if errors.Is(err, amount.ErrPrecision) {
+			return response(400, "points_per_kg allows 2 decimal places and min_kg 3"), nil
+		}
+		return response(400, "Invalid request body"), nil
+	}
+
+	m, err := rules.ParseMaterial(string(r.Material))
+	if err != nil {
+		return response(400, err.Error()), nil
+	}
+	r.Material = m
+	if r.Rounding == "" {
+		r.Rounding = rules.RoundNone
+	}
+	if r.EffectiveFrom == "" {
+		r.EffectiveFrom = time.Now().UTC().Format(time.RFC3339)
+	} else if t, err := time.Parse(time.RFC3339, r.EffectiveFrom); err == nil {
+		// Normalise so rule IDs sort by time
+		r.EffectiveFrom = t.UTC().Format(time.RFC3339)
+	}
+	r.CreatedBy = caller.UserID
+	if err := r.Validate(); err != nil {
+		return response(400, err.Error()), nil
+	}
+
+	_, err = dbClient.PutItem(ctx, rules.PutInput(tableName, r))
+	if dberr.ConditionFailed(err) {
+		return response(409, "A rule for this material already starts at effective_from"), nil
+	}
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to save earning rule"), nil
+	}
+	return jsonResponse(201, r), nil
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
+		"Access-Control-Allow-Methods": "GET,POST,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
+}
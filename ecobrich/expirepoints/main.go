@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/dberr"
+	"hello-world/internal/ledger"
+)
+
+// ExpireEvent is the input of both the daily schedule and manual runs.
+type ExpireEvent struct {
+	DryRun  bool     `json:"dry_run"`            // Only report what would expire
+	UserIDs []string `json:"user_ids,omitempty"` // Limit the run to these users, default is everyone
+}
+
+type UserExpiry struct {
+	UserID string        `json:"user_id"`
+	Lots   int           `json:"lots"`
+	Points amount.Points `json:"points"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type Report struct {
+	DryRun       bool          `json:"dry_run"`
+	UsersChecked int           `json:"users_checked"`
+	Expired      []UserExpiry  `json:"expired"`
+	Points       amount.Points `json:"points"`
+}
+
+// maxLotsPerRun keeps one user's write within a single transaction
+// (100 items, one of them the PROFILE update). Older lots go first and the
+// rest are picked up by the next run.
+const maxLotsPerRun = 99
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+// handleRequest writes an EXPIRE entry for every lot that reached its expiry
+// with points left, and takes those points off PROFILE.
+func handleRequest(ctx context.Context, event ExpireEvent) (Report, error) {
+	userIDs := event.UserIDs
+	if len(userIDs) == 0 {
+		var err error
+		if userIDs, err = listUsers(ctx); err != nil {
+			return Report{}, err
+		}
+	}
+
+	now := time.Now()
+	report := Report{DryRun: event.DryRun, Expired: []UserExpiry{}}
+	for _, userID := range userIDs {
+		u, err := expireUser(ctx, userID, now, event.DryRun)
+		if err != nil {
+			// One bad partition must not stop the run for everyone after it
+			fmt.Println("Expire Error:", userID, err)
+			u = UserExpiry{UserID: userID, Error: err.Error()}
+		}
+		report.UsersChecked++
+		if u.Lots == 0 && u.Error == "" {
+			continue
+		}
+		fmt.Printf("Expired for %s: %d lots, %s points %s\n", userID, u.Lots, u.Points, u.Error)
+		report.Points += u.Points
+		report.Expired = append(report.Expired, u)
+	}
+	return report, nil
+}
+
+func expireUser(ctx context.Context, userID string, now time.Time, dryRun bool) (UserExpiry, error) {
+	u := UserExpiry{UserID: userID}
+
+	// Version first, like redeem: a spend landing after the read cancels us
+	version, err := ledger.BalanceVersion(ctx, dbClient, tableName, userID)
+	if err != nil {
+		return u, err
+	}
+	entries, err := ledger.Load(ctx, dbClient, tableName, userID)
+	if err != nil {
+		return u, err
+	}
+	due := ledger.Due(ledger.Lots(entries), now)
+	if len(due) > maxLotsPerRun {
+		due = due[:maxLotsPerRun]
+	}
+	if len(due) == 0 {
+		return u, nil
+	}
+
+	timestamp := now.Format(time.RFC3339)
+	items := make([]types.TransactWriteItem, 0, len(due)+1)
+	for _, lot := range due {
+		u.Lots++
+		u.Points += lot.Remaining
+		items = append(items, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String(tableName),
+			Item: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+				// One EXPIRE per lot, so a re-run cannot expire a lot twice
+				"SK":          &types.AttributeValueMemberS{Value: "EXPIRE#" + lot.SK[strings.IndexByte(lot.SK, '#')+1:]},
+				"Type":        &types.AttributeValueMemberS{Value: string(ledger.TypeExpire)},
+				"PointsSpent": lot.Remaining.Attr(),
+				"LotSK":       &types.AttributeValueMemberS{Value: lot.SK},
+				"Status":      &types.AttributeValueMemberS{Value: ledger.StatusApproved},
+				"Note":        &types.AttributeValueMemberS{Value: fmt.Sprintf("Points earned on %s expired", lot.EarnedAt.Format("2006-01-02"))},
+				"CreatedAt":   &types.AttributeValueMemberS{Value: timestamp},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(SK)"),
+		}})
+	}
+	if dryRun {
+		return u, nil
+	}
+	items = append(items, ledger.ProfileUpdate(tableName, userID, ledger.Delta{Points: -u.Points}, timestamp, &version))
+
+	_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if dberr.ConditionFailed(err) {
+		// Balance moved or a lot was already expired; the next run retries
+		u.Error = "ledger changed during expiry, skipped"
+		u.Lots, u.Points = 0, 0
+		return u, nil
+	}
+	return u, err
+}
+
+// listUsers finds every user partition.
+func listUsers(ctx context.Context) ([]string, error) {
+	p := dynamodb.NewScanPaginator(dbClient, &dynamodb.ScanInput{
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String("PK"),
+		FilterExpression:     aws.String("begins_with(PK, :u)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: ledger.UserPK("")},
+		},
+	})
+
+	seen := map[string]bool{}
+	var users []string
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			pk, ok := item["PK"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			userID := strings.TrimPrefix(pk.Value, ledger.UserPK(""))
+			if !seen[userID] {
+				seen[userID] = true
+				users = append(users, userID)
+			}
+		}
+	}
+	return users, nil
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
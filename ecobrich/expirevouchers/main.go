@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/dberr"
+	"hello-world/internal/validity"
+	"hello-world/internal/wallet"
+)
+
+// ExpireEvent is the input of both the daily schedule and manual runs.
+type ExpireEvent struct {
+	DryRun bool `json:"dry_run"` // Only report what would expire
+}
+
+type ExpiredVoucher struct {
+	PK        string `json:"pk"`
+	SK        string `json:"sk"`
+	Code      string `json:"code"`
+	ExpiresAt string `json:"expires_at"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Report struct {
+	DryRun  bool             `json:"dry_run"`
+	Checked int              `json:"checked"`
+	Expired []ExpiredVoucher `json:"expired"`
+	Skipped int              `json:"skipped"` // Used or changed while the job ran
+	Failed  []ExpiredVoucher `json:"failed"`  // Left active by a write error, retried next run
+	// Definitions given the ExpirySort key they were created without
+	Backfilled int `json:"backfilled"`
+	// User vouchers given the active Status they were written without
+	StatusBackfilled int `json:"status_backfilled"`
+}
+
+const statusIndex = "StatusIndex"
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+// handleRequest marks claimed vouchers whose validity window has ended as
+// expired, so members and partners see the same state the clock implies.
+// Only active USER_VOUCHER items are read, through the StatusIndex GSI.
+// Definitions missing their ExpirySort key, and user vouchers missing their
+// Status, are fixed on the way.
+func handleRequest(ctx context.Context, event ExpireEvent) (Report, error) {
+	report := Report{DryRun: event.DryRun, Expired: []ExpiredVoucher{}, Failed: []ExpiredVoucher{}}
+	var err error
+	if report.Backfilled, err = backfillExpirySort(ctx, event.DryRun); err != nil {
+		return report, fmt.Errorf("backfill ExpirySort: %w", err)
+	}
+	if report.StatusBackfilled, err = backfillStatus(ctx, event.DryRun); err != nil {
+		return report, fmt.Errorf("backfill Status: %w", err)
+	}
+
+	p := dynamodb.NewQueryPaginator(dbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(statusIndex),
+		KeyConditionExpression: aws.String("#status = :active"),
+		FilterExpression:       aws.String("#type = :uv"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+			"#type":   "Type",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":active": &types.AttributeValueMemberS{Value: wallet.StatusActive},
+			":uv":     &types.AttributeValueMemberS{Value: wallet.Type},
+		},
+	})
+
+	now := time.Now()
+	timestamp := now.Format(time.RFC3339)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return report, err
+		}
+		for _, item := range out.Items {
+			report.Checked++
+			v := ExpiredVoucher{
+				PK:        stringAttr(item, "PK"),
+				SK:        stringAttr(item, "SK"),
+				Code:      stringAttr(item, "Code"),
+				ExpiresAt: stringAttr(item, "ExpiresAt"),
+			}
+			if validity.FromStrings("", v.ExpiresAt).At(now) != validity.Expired {
+				continue
+			}
+			if !event.DryRun {
+				if err := expire(ctx, v, timestamp); dberr.ConditionFailed(err) {
+					report.Skipped++
+					continue
+				} else if err != nil {
+					// One bad item must not stop the run for the rest
+					fmt.Println("Expire Error:", v.PK, v.SK, err)
+					v.Error = err.Error()
+					report.Failed = append(report.Failed, v)
+					continue
+				}
+			}
+			fmt.Printf("Expired %s %s (%s)\n", v.PK, v.SK, v.ExpiresAt)
+			report.Expired = append(report.Expired, v)
+		}
+	}
+	return report, nil
+}
+
+// expire only moves vouchers that are still active, so one consumed by a
+// partner while the job runs keeps its used state.
+func expire(ctx context.Context, v ExpiredVoucher, timestamp string) error {
+	_, err := dbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: v.PK},
+			"SK": &types.AttributeValueMemberS{Value: v.SK},
+		},
+		UpdateExpression:         aws.String("SET #status = :expired, ExpiredAt = :now"),
+		ConditionExpression:      aws.String("#status = :active"),
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expired": &types.AttributeValueMemberS{Value: wallet.StatusExpired},
+			":active":  &types.AttributeValueMemberS{Value: wallet.StatusActive},
+			":now":     &types.AttributeValueMemberS{Value: timestamp},
+		},
+	})
+	return err
+}
+
+// backfillExpirySort indexes definitions created before VoucherExpiryIndex,
+// so sort=expiry lists the whole catalog.
+func backfillExpirySort(ctx context.Context, dryRun bool) (int, error) {
+	p := dynamodb.NewQueryPaginator(dbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		FilterExpression:       aws.String("attribute_not_exists(ExpirySort)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: "VOUCHER"},
+		},
+	})
+	n := 0
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return n, err
+		}
+		for _, item := range out.Items {
+			n++
+			if dryRun {
+				continue
+			}
+			_, err := dbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(tableName),
+				Key: map[string]types.AttributeValue{
+					"PK": item["PK"],
+					"SK": item["SK"],
+				},
+				UpdateExpression:    aws.String("SET ExpirySort = :sort"),
+				ConditionExpression: aws.String("attribute_exists(SK) AND attribute_not_exists(ExpirySort)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":sort": &types.AttributeValueMemberS{Value: validity.SortKey(stringAttr(item, "ExpiresAt"))},
+				},
+			})
+			if err != nil && !dberr.ConditionFailed(err) {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// backfillStatus marks USER_VOUCHER items written before statuses were
+// tracked as active, which wallet.FromItem already takes them to be. Without
+// a Status they are missing from StatusIndex and would never expire. Items
+// written since always carry one, so once done this finds nothing to fix.
+func backfillStatus(ctx context.Context, dryRun bool) (int, error) {
+	p := dynamodb.NewScanPaginator(dbClient, &dynamodb.ScanInput{
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String("PK, SK"),
+		FilterExpression:     aws.String("#type = :uv AND attribute_not_exists(#status)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+			"#type":   "Type",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uv": &types.AttributeValueMemberS{Value: wallet.Type},
+		},
+	})
+	n := 0
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return n, err
+		}
+		for _, item := range out.Items {
+			n++
+			if dryRun {
+				continue
+			}
+			_, err := dbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(tableName),
+				Key: map[string]types.AttributeValue{
+					"PK": item["PK"],
+					"SK": item["SK"],
+				},
+				UpdateExpression:         aws.String("SET #status = :active"),
+				ConditionExpression:      aws.String("attribute_exists(SK) AND attribute_not_exists(#status)"),
+				ExpressionAttributeNames: map[string]string{"#status": "Status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":active": &types.AttributeValueMemberS{Value: wallet.StatusActive},
+				},
+			})
+			if err != nil && !dberr.ConditionFailed(err) {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
@@ -0,0 +1,275 @@
+// Package amount holds the fixed-point types used for balances. Points are
+// counted in hundredths and weights in grams, both as int64, so sums are
+// exact: ten 0.1 kg donations are exactly 1 kg.
+//
+// The text form (JSON and DynamoDB N attributes) stays a plain decimal in
+// points and kilograms, e.g. "12.5" and "0.1", so the stored attributes and
+// API fields keep their names and units. DynamoDB numbers are decimals too,
+// which keeps ADD on PROFILE exact.
+package amount
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// PointDecimals is the precision of Points.
+	PointDecimals = 2
+	// KgDecimals is the precision of Grams when written in kilograms.
+	KgDecimals = 3
+
+	// Point is one whole point.
+	Point Points = 100
+	// Kg is one kilogram.
+	Kg Grams = 1000
+)
+
+var (
+	ErrInvalid   = errors.New("amount: not a decimal number")
+	ErrPrecision = errors.New("amount: too many decimal places")
+	ErrRange     = errors.New("amount: out of range")
+)
+
+// Points is an amount of points in hundredths.
+type Points int64
+
+// Grams is a weight of plastic. Its text form is in kilograms.
+type Grams int64
+
+// ParsePoints parses a decimal such as "12.5". More than two decimal places
+// is an error unless the extra digits are zeros.
+func ParsePoints(s string) (Points, error) {
+	v, err := parse(s, PointDecimals, false)
+	return Points(v), err
+}
+
+// ParseKg parses a weight in kilograms such as "0.25". Anything finer than
+// a gram is an error.
+func ParseKg(s string) (Grams, error) {
+	v, err := parse(s, KgDecimals, false)
+	return Grams(v), err
+}
+
+func (p Points) String() string { return format(int64(p), PointDecimals) }
+func (g Grams) String() string  { return format(int64(g), KgDecimals) }
+
+// Whole returns the number of whole points, truncated towards zero.
+func (p Points) Whole() int64 { return int64(p) / int64(Point) }
+
+// Rounding selects how Points.Round treats a fractional point.
+type Rounding int
+
+const (
+	Floor Rounding = iota
+	HalfUp
+	Ceil
+)
+
+// Round rounds p to whole points.
+func (p Points) Round(mode Rounding) Points {
+	return Points(divRound(int64(p), int64(Point), mode) * int64(Point))
+}
+
+// PerKg applies a rate in points per kilogram to a weight, rounding the
+// result half up to the nearest hundredth of a point.
+func (rate Points) PerKg(g Grams) (Points, error) {
+	if g != 0 && (int64(rate) > math.MaxInt64/abs(int64(g)) || int64(rate) < math.MinInt64/abs(int64(g))) {
+		return 0, ErrRange
+	}
+	return Points(divRound(int64(rate)*int64(g), int64(Kg), HalfUp)), nil
+}
+
+// Percent returns pct percent of p, rounded half up to the nearest
+// hundredth of a point.
+func (p Points) Percent(pct int64) (Points, error) {
+	if pct != 0 && (int64(p) > math.MaxInt64/abs(pct) || int64(p) < math.MinInt64/abs(pct)) {
+		return 0, ErrRange
+	}
+	return Points(divRound(int64(p)*pct, 100, HalfUp)), nil
+}
+
+// MarshalJSON writes p as a JSON number.
+func (p Points) MarshalJSON() ([]byte, error) { return []byte(p.String()), nil }
+
+// UnmarshalJSON accepts a JSON number or a numeric string.
+func (p *Points) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	v, err := parse(unquote(b), PointDecimals, false)
+	if err != nil {
+		return err
+	}
+	*p = Points(v)
+	return nil
+}
+
+// MarshalJSON writes g as a JSON number of kilograms.
+func (g Grams) MarshalJSON() ([]byte, error) { return []byte(g.String()), nil }
+
+// UnmarshalJSON accepts kilograms as a JSON number or a numeric string.
+func (g *Grams) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	v, err := parse(unquote(b), KgDecimals, false)
+	if err != nil {
+		return err
+	}
+	*g = Grams(v)
+	return nil
+}
+
+// Attr encodes p as a DynamoDB number.
+func (p Points) Attr() types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: p.String()}
+}
+
+// Attr encodes g as a DynamoDB number of kilograms.
+func (g Grams) Attr() types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: g.String()}
+}
+
+// PointsAttr decodes the named attribute. A missing attribute is zero.
+// Values written by the old float code ("15.000000", "0.30000000000000004")
+// are rounded to the nearest hundredth rather than rejected.
+func PointsAttr(item map[string]types.AttributeValue, name string) (Points, error) {
+	v, err := attr(item, name, PointDecimals)
+	return Points(v), err
+}
+
+// GramsAttr decodes the named attribute, stored in kilograms.
+func GramsAttr(item map[string]types.AttributeValue, name string) (Grams, error) {
+	v, err := attr(item, name, KgDecimals)
+	return Grams(v), err
+}
+
+func attr(item map[string]types.AttributeValue, name string, decimals int) (int64, error) {
+	n, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	v, err := parse(n.Value, decimals, true)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q: %w", name, n.Value, err)
+	}
+	return v, nil
+}
+
+// parse reads a plain decimal into an integer scaled by 10^decimals. With
+// round set, extra digits are rounded half up; otherwise they must be zero.
+func parse(s string, decimals int, round bool) (int64, error) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, frac, _ := strings.Cut(s, ".")
+	if intPart == "" && frac == "" || !digits(intPart) || !digits(frac) {
+		return 0, ErrInvalid
+	}
+
+	var extra string
+	if len(frac) > decimals {
+		frac, extra = frac[:decimals], frac[decimals:]
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+
+	v, err := strconv.ParseInt(intPart+frac, 10, 64)
+	if err != nil {
+		return 0, ErrRange
+	}
+
+	if strings.Trim(extra, "0") != "" {
+		if !round {
+			return 0, ErrPrecision
+		}
+		if extra[0] >= '5' {
+			if v == math.MaxInt64 {
+				return 0, ErrRange
+			}
+			v++
+		}
+	}
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+func format(v int64, decimals int) string {
+	neg := v < 0
+	u := uint64(v)
+	if neg {
+		u = -u
+	}
+	s := strconv.FormatUint(u, 10)
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	intPart, frac := s[:len(s)-decimals], strings.TrimRight(s[len(s)-decimals:], "0")
+	if frac != "" {
+		intPart += "." + frac
+	}
+	if neg {
+		intPart = "-" + intPart
+	}
+	return intPart
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func unquote(b []byte) string {
+	s := string(b)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func divRound(n, d int64, mode Rounding) int64 {
+	q, r := n/d, n%d
+	if r == 0 {
+		return q
+	}
+	switch mode {
+	case Floor:
+		if r < 0 {
+			q--
+		}
+	case Ceil:
+		if r > 0 {
+			q++
+		}
+	default:
+		if 2*abs(r) >= d {
+			if n < 0 {
+				q--
+			} else {
+				q++
+			}
+		}
+	}
+	return q
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,153 @@
+package amount
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestParseKg(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected Grams
+		err      error
+	}{
+		{in: "1", expected: 1000},
+		{in: "0.1", expected: 100},
+		{in: ".25", expected: 250},
+		{in: "2.", expected: 2000},
+		{in: "1.500000", expected: 1500},
+		{in: "-0.5", expected: -500},
+		{in: "0.0001", err: ErrPrecision},
+		{in: "", err: ErrInvalid},
+		{in: ".", err: ErrInvalid},
+		{in: "1e3", err: ErrInvalid},
+		{in: "NaN", err: ErrInvalid},
+		{in: "99999999999999999999", err: ErrRange},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.in, func(t *testing.T) {
+			got, err := ParseKg(testCase.in)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected error %v, but got %v", testCase.err, err)
+			}
+			if got != testCase.expected {
+				t.Errorf("Expected %d g, but got %d", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	testCases := []struct {
+		got      string
+		expected string
+	}{
+		{Points(1250).String(), "12.5"},
+		{Points(5).String(), "0.05"},
+		{Points(-30).String(), "-0.3"},
+		{Points(0).String(), "0"},
+		{Grams(100).String(), "0.1"},
+		{Grams(1500).String(), "1.5"},
+	}
+	for _, testCase := range testCases {
+		if testCase.got != testCase.expected {
+			t.Errorf("Expected %q, but got %q", testCase.expected, testCase.got)
+		}
+	}
+}
+
+func TestSumIsExact(t *testing.T) {
+	// Ten 0.1 kg donations at 10 points/kg: the float code ended at 9.999...
+	var kg Grams
+	var points Points
+	for i := 0; i < 10; i++ {
+		g, _ := ParseKg("0.1")
+		p, err := (10 * Point).PerKg(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kg += g
+		points += p
+	}
+	if kg != Kg || points != 10*Point {
+		t.Errorf("Expected 1 kg and 10 points, but got %v kg and %v points", kg, points)
+	}
+}
+
+func TestRound(t *testing.T) {
+	testCases := []struct {
+		name     string
+		p        Points
+		mode     Rounding
+		expected Points
+	}{
+		{"floor", 950, Floor, 900},
+		{"half up", 350, HalfUp, 400},
+		{"half up below", 349, HalfUp, 300},
+		{"ceil", 70, Ceil, 100},
+		{"whole is untouched", 700, Ceil, 700},
+		{"negative floor", -150, Floor, -200},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := testCase.p.Round(testCase.mode); got != testCase.expected {
+				t.Errorf("Expected %v, but got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPercent(t *testing.T) {
+	if got, _ := Points(1005).Percent(110); got != 1106 {
+		t.Errorf("Expected 11.06, but got %v", got)
+	}
+	if _, err := Points(math.MaxInt64 / 2).Percent(300); !errors.Is(err, ErrRange) {
+		t.Errorf("Expected ErrRange, but got %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var body struct {
+		Amount Grams  `json:"amount"`
+		Points Points `json:"points"`
+	}
+	if err := json.Unmarshal([]byte(`{"amount":0.3,"points":"12.5"}`), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Amount != 300 || body.Points != 1250 {
+		t.Errorf("Unexpected decode %+v", body)
+	}
+	out, _ := json.Marshal(body)
+	if string(out) != `{"amount":0.3,"points":12.5}` {
+		t.Errorf("Unexpected encode %s", out)
+	}
+
+	if err := json.Unmarshal([]byte(`{"amount":0.0005}`), &body); !errors.Is(err, ErrPrecision) {
+		t.Errorf("Expected ErrPrecision, but got %v", err)
+	}
+}
+
+func TestAttrToleratesLegacyFloats(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"TotalPoints": &types.AttributeValueMemberN{Value: "0.30000000000000004"},
+		"AmountKg":    &types.AttributeValueMemberN{Value: "1.500000"},
+		"Bad":         &types.AttributeValueMemberN{Value: "ten"},
+	}
+	if p, err := PointsAttr(item, "TotalPoints"); err != nil || p != 30 {
+		t.Errorf("Expected 0.3 points, but got %v (%v)", p, err)
+	}
+	if g, err := GramsAttr(item, "AmountKg"); err != nil || g != 1500 {
+		t.Errorf("Expected 1500 g, but got %v (%v)", g, err)
+	}
+	if p, err := PointsAttr(item, "Missing"); err != nil || p != 0 {
+		t.Errorf("Expected a missing attribute to be zero, but got %v (%v)", p, err)
+	}
+	if _, err := PointsAttr(item, "Bad"); err == nil {
+		t.Errorf("Expected an error for a malformed number")
+	}
+}
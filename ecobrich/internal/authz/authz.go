@@ -0,0 +1,162 @@
+// Package authz maps the Cognito groups on a request to roles and
+// permissions. Every privileged handler calls Authorize with the permission
+// it needs instead of inspecting cognito:groups itself.
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Role is a Cognito group name.
+type Role string
+
+const (
+	RoleAdmin    Role = "Admin"
+	RoleOperator Role = "Operator" // Collection point staff
+	RolePartner  Role = "Partner"  // Merchant accepting vouchers
+	RoleUser     Role = "User"     // Every signed-in user, group or not
+)
+
+// Permission is a single privileged operation.
+type Permission string
+
+const (
+	PermAwardPoints      Permission = "award_points"
+	PermReviewDonations  Permission = "review_donations"
+	PermManageVouchers   Permission = "manage_vouchers"
+	PermValidateVouchers Permission = "validate_vouchers"
+	PermManageConfig     Permission = "manage_config"
+	PermReverseRedeems   Permission = "reverse_redeems"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermAwardPoints,
+		PermReviewDonations,
+		PermManageVouchers,
+		PermValidateVouchers,
+		PermManageConfig,
+		PermReverseRedeems,
+	},
+	RoleOperator: {
+		PermAwardPoints,
+		PermReviewDonations,
+	},
+	RolePartner: {
+		PermValidateVouchers,
+	},
+	RoleUser: {},
+}
+
+var (
+	ErrUnauthenticated = errors.New("Unauthorized")
+	ErrForbidden       = errors.New("Access Denied")
+)
+
+// Identity is the authenticated caller.
+type Identity struct {
+	UserID string
+	Email  string
+	Roles  []Role
+	Claims map[string]interface{}
+}
+
+// Has reports whether the caller is in role.
+func (id Identity) Has(role Role) bool {
+	for _, r := range id.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether any of the caller's roles grants perm.
+func (id Identity) Can(perm Permission) bool {
+	for _, r := range id.Roles {
+		for _, p := range rolePermissions[r] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FromRequest reads the Cognito authorizer claims. It fails with
+// ErrUnauthenticated when the request did not pass the authorizer.
+func FromRequest(request events.APIGatewayProxyRequest) (Identity, error) {
+	claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{})
+	if !ok {
+		return Identity{}, ErrUnauthenticated
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+	email, _ := claims["email"].(string)
+
+	roles := []Role{RoleUser}
+	for _, g := range ParseGroups(claims["cognito:groups"]) {
+		if r, ok := roleFor(g); ok && r != RoleUser {
+			roles = append(roles, r)
+		}
+	}
+	return Identity{UserID: sub, Email: email, Roles: roles, Claims: claims}, nil
+}
+
+// Authorize returns the caller if they hold perm. The error is
+// ErrUnauthenticated or wraps ErrForbidden; StatusCode maps it to HTTP.
+func Authorize(request events.APIGatewayProxyRequest, perm Permission) (Identity, error) {
+	id, err := FromRequest(request)
+	if err != nil {
+		return Identity{}, err
+	}
+	if !id.Can(perm) {
+		return id, fmt.Errorf("%w: %s permission required", ErrForbidden, perm)
+	}
+	return id, nil
+}
+
+// StatusCode is 401 for ErrUnauthenticated and 403 otherwise.
+func StatusCode(err error) int {
+	if errors.Is(err, ErrUnauthenticated) {
+		return 401
+	}
+	return 403
+}
+
+// ParseGroups normalises cognito:groups. Depending on the API Gateway flavour
+// it arrives as a JSON list, or flattened to "[Admin Partner]" or
+// "Admin,Partner".
+func ParseGroups(v interface{}) []string {
+	var groups []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case []string:
+		groups = append(groups, v...)
+	case string:
+		groups = strings.FieldsFunc(strings.Trim(v, "[]"), func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	return groups
+}
+
+func roleFor(group string) (Role, bool) {
+	for r := range rolePermissions {
+		if strings.EqualFold(string(r), group) {
+			return r, true
+		}
+	}
+	return "", false
+}
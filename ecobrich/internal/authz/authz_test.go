@@ -0,0 +1,106 @@
+package authz
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func requestWithClaims(claims map[string]interface{}) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Authorizer: map[string]interface{}{"claims": claims},
+		},
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	testCases := []struct {
+		name     string
+		groups   interface{}
+		expected []string
+	}{
+		{name: "missing", groups: nil, expected: nil},
+		{name: "json list", groups: []interface{}{"Admin", "Partner"}, expected: []string{"Admin", "Partner"}},
+		{name: "string list", groups: []string{"Operator"}, expected: []string{"Operator"}},
+		{name: "bracketed", groups: "[Admin Partner]", expected: []string{"Admin", "Partner"}},
+		{name: "comma separated", groups: "Admin, Operator", expected: []string{"Admin", "Operator"}},
+		{name: "single", groups: "Partner", expected: []string{"Partner"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := ParseGroups(testCase.groups)
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("Expected %v, but got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	testCases := []struct {
+		name           string
+		request        events.APIGatewayProxyRequest
+		perm           Permission
+		expectedStatus int // 0 means allowed
+	}{
+		{
+			name:           "no authorizer",
+			request:        events.APIGatewayProxyRequest{},
+			perm:           PermAwardPoints,
+			expectedStatus: 401,
+		},
+		{
+			name:           "plain user",
+			request:        requestWithClaims(map[string]interface{}{"sub": "u1"}),
+			perm:           PermManageVouchers,
+			expectedStatus: 403,
+		},
+		{
+			name:    "admin",
+			request: requestWithClaims(map[string]interface{}{"sub": "u1", "cognito:groups": "[Admin]"}),
+			perm:    PermManageVouchers,
+		},
+		{
+			name:    "operator reviews donations",
+			request: requestWithClaims(map[string]interface{}{"sub": "u1", "cognito:groups": []interface{}{"operator"}}),
+			perm:    PermReviewDonations,
+		},
+		{
+			name:           "operator cannot mint vouchers",
+			request:        requestWithClaims(map[string]interface{}{"sub": "u1", "cognito:groups": "Operator"}),
+			perm:           PermManageVouchers,
+			expectedStatus: 403,
+		},
+		{
+			name:           "unknown group grants nothing",
+			request:        requestWithClaims(map[string]interface{}{"sub": "u1", "cognito:groups": "Admins"}),
+			perm:           PermAwardPoints,
+			expectedStatus: 403,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := Authorize(testCase.request, testCase.perm)
+			if testCase.expectedStatus == 0 {
+				if err != nil {
+					t.Errorf("Expected access, but got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected status %d, but access was granted", testCase.expectedStatus)
+			}
+			if got := StatusCode(err); got != testCase.expectedStatus {
+				t.Errorf("Expected status %d, but got %d", testCase.expectedStatus, got)
+			}
+			if testCase.expectedStatus == 403 && !errors.Is(err, ErrForbidden) {
+				t.Errorf("Expected ErrForbidden, but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Package campaigns holds promotional earning campaigns, such as double
+// points for PET during Earth Week. A campaign targets materials and
+// collection points for a time window and adds a multiplier or a flat bonus
+// on top of the earning rule. Campaigns are stored under PK=CONFIG next to
+// the rules and tiers.
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/rules"
+)
+
+const (
+	configPK       = "CONFIG"
+	campaignPrefix = "CAMPAIGN#"
+)
+
+// Campaign is stored as PK=CONFIG, SK=CAMPAIGN#<id>.
+type Campaign struct {
+	ID                string           `json:"id"`
+	Name              string           `json:"name"`
+	StartsAt          string           `json:"starts_at"`                    // RFC3339, inclusive
+	EndsAt            string           `json:"ends_at"`                      // RFC3339, exclusive
+	Materials         []rules.Material `json:"materials"`                    // Empty targets every material
+	CollectionPoints  []string         `json:"collection_points"`            // Empty targets every collection point
+	MultiplierPercent int64            `json:"multiplier_percent,omitempty"` // 200 doubles the points, 0 or 100 adds nothing
+	BonusPoints       amount.Points    `json:"bonus_points,omitempty"`       // Flat bonus per donation
+	CreatedBy         string           `json:"created_by,omitempty"`
+	CreatedAt         string           `json:"created_at,omitempty"`
+}
+
+// Validate checks a campaign submitted by an admin and normalises its
+// materials.
+func (c *Campaign) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return errors.New("name is required")
+	}
+	starts, err := time.Parse(time.RFC3339, c.StartsAt)
+	if err != nil {
+		return errors.New("starts_at must be an RFC3339 timestamp")
+	}
+	ends, err := time.Parse(time.RFC3339, c.EndsAt)
+	if err != nil {
+		return errors.New("ends_at must be an RFC3339 timestamp")
+	}
+	if !ends.After(starts) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	for i, m := range c.Materials {
+		if c.Materials[i], err = rules.ParseMaterial(string(m)); err != nil || m == "" {
+			return fmt.Errorf("%w %q", rules.ErrUnknownMaterial, m)
+		}
+	}
+	if c.MultiplierPercent != 0 && c.MultiplierPercent < 100 {
+		return errors.New("multiplier_percent must be at least 100")
+	}
+	if c.BonusPoints < 0 {
+		return errors.New("bonus_points must not be negative")
+	}
+	if c.MultiplierPercent <= 100 && c.BonusPoints == 0 {
+		return errors.New("a campaign needs a multiplier_percent above 100 or bonus_points")
+	}
+	return nil
+}
+
+// Matches reports whether a donation of material at point, made at the
+// given time, is covered by the campaign.
+func (c Campaign) Matches(material rules.Material, point string, at time.Time) bool {
+	starts, err1 := time.Parse(time.RFC3339, c.StartsAt)
+	ends, err2 := time.Parse(time.RFC3339, c.EndsAt)
+	if err1 != nil || err2 != nil || at.Before(starts) || !at.Before(ends) {
+		return false
+	}
+	if len(c.Materials) > 0 && !contains(c.Materials, material) {
+		return false
+	}
+	if len(c.CollectionPoints) > 0 {
+		found := false
+		for _, p := range c.CollectionPoints {
+			if strings.EqualFold(p, point) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Bonus is the number of points the campaign adds to points.
+func (c Campaign) Bonus(points amount.Points) (amount.Points, error) {
+	bonus := c.BonusPoints
+	if c.MultiplierPercent > 100 {
+		boosted, err := points.Percent(c.MultiplierPercent)
+		if err != nil {
+			return 0, err
+		}
+		bonus += boosted - points
+	}
+	return bonus, nil
+}
+
+// Best picks the matching campaign that adds the most points. Campaigns do
+// not stack, so overlapping events cannot multiply each other. ok is false
+// when no campaign matches.
+func Best(all []Campaign, material rules.Material, point string, at time.Time, points amount.Points) (best Campaign, bonus amount.Points, ok bool, err error) {
+	for _, c := range all {
+		if !c.Matches(material, point, at) {
+			continue
+		}
+		b, err := c.Bonus(points)
+		if err != nil {
+			return Campaign{}, 0, false, err
+		}
+		if !ok || b > bonus {
+			best, bonus, ok = c, b, true
+		}
+	}
+	return best, bonus, ok, nil
+}
+
+// Load reads every campaign, oldest first.
+func Load(ctx context.Context, api dynamodb.QueryAPIClient, table string) ([]Campaign, error) {
+	p := dynamodb.NewQueryPaginator(api, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :c)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: configPK},
+			":c":  &types.AttributeValueMemberS{Value: campaignPrefix},
+		},
+	})
+
+	var all []Campaign
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			c, err := fromItem(item)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, c)
+		}
+	}
+	return all, nil
+}
+
+// PutInput stores a new campaign.
+func PutInput(table string, c Campaign) *dynamodb.PutItemInput {
+	materials := make([]types.AttributeValue, len(c.Materials))
+	for i, m := range c.Materials {
+		materials[i] = &types.AttributeValueMemberS{Value: string(m)}
+	}
+	points := make([]types.AttributeValue, len(c.CollectionPoints))
+	for i, p := range c.CollectionPoints {
+		points[i] = &types.AttributeValueMemberS{Value: p}
+	}
+	return &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"PK":                &types.AttributeValueMemberS{Value: configPK},
+			"SK":                &types.AttributeValueMemberS{Value: campaignPrefix + c.ID},
+			"Type":              &types.AttributeValueMemberS{Value: "CAMPAIGN"},
+			"Name":              &types.AttributeValueMemberS{Value: c.Name},
+			"StartsAt":          &types.AttributeValueMemberS{Value: c.StartsAt},
+			"EndsAt":            &types.AttributeValueMemberS{Value: c.EndsAt},
+			"Materials":         &types.AttributeValueMemberL{Value: materials},
+			"CollectionPoints":  &types.AttributeValueMemberL{Value: points},
+			"MultiplierPercent": &types.AttributeValueMemberN{Value: strconv.FormatInt(c.MultiplierPercent, 10)},
+			"BonusPoints":       c.BonusPoints.Attr(),
+			"CreatedBy":         &types.AttributeValueMemberS{Value: c.CreatedBy},
+			"CreatedAt":         &types.AttributeValueMemberS{Value: c.CreatedAt},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(SK)"),
+	}
+}
+
+func fromItem(item map[string]types.AttributeValue) (Campaign, error) {
+	c := Campaign{
+		ID:               strings.TrimPrefix(stringAttr(item, "SK"), campaignPrefix),
+		Name:             stringAttr(item, "Name"),
+		StartsAt:         stringAttr(item, "StartsAt"),
+		EndsAt:           stringAttr(item, "EndsAt"),
+		CreatedBy:        stringAttr(item, "CreatedBy"),
+		CreatedAt:        stringAttr(item, "CreatedAt"),
+		Materials:        []rules.Material{},
+		CollectionPoints: []string{},
+	}
+	for _, s := range listAttr(item, "Materials") {
+		c.Materials = append(c.Materials, rules.Material(s))
+	}
+	c.CollectionPoints = append(c.CollectionPoints, listAttr(item, "CollectionPoints")...)
+	var err error
+	if v, ok := item["MultiplierPercent"].(*types.AttributeValueMemberN); ok {
+		if c.MultiplierPercent, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+			return Campaign{}, fmt.Errorf("campaigns: %s: MultiplierPercent %q: %w", c.ID, v.Value, err)
+		}
+	}
+	if c.BonusPoints, err = amount.PointsAttr(item, "BonusPoints"); err != nil {
+		return Campaign{}, fmt.Errorf("campaigns: %s: %w", c.ID, err)
+	}
+	return c, nil
+}
+
+func contains(materials []rules.Material, m rules.Material) bool {
+	for _, x := range materials {
+		if x == m {
+			return true
+		}
+	}
+	return false
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func listAttr(item map[string]types.AttributeValue, name string) []string {
+	var out []string
+	if v, ok := item[name].(*types.AttributeValueMemberL); ok {
+		for _, e := range v.Value {
+			if s, ok := e.(*types.AttributeValueMemberS); ok {
+				out = append(out, s.Value)
+			}
+		}
+	}
+	return out
+}
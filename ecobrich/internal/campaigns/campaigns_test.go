@@ -0,0 +1,83 @@
+package campaigns
+
+import (
+	"testing"
+	"time"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/rules"
+)
+
+var earthWeek = Campaign{
+	ID:                "earth",
+	Name:              "Earth Week",
+	StartsAt:          "2025-04-20T00:00:00Z",
+	EndsAt:            "2025-04-27T00:00:00Z",
+	Materials:         []rules.Material{rules.MaterialPET},
+	MultiplierPercent: 200,
+}
+
+var districtOne = Campaign{
+	ID:               "d1",
+	Name:             "District 1 opening",
+	StartsAt:         "2025-04-01T00:00:00Z",
+	EndsAt:           "2025-05-01T00:00:00Z",
+	CollectionPoints: []string{"Q1-BenThanh"},
+	BonusPoints:      5 * amount.Point,
+}
+
+func TestBest(t *testing.T) {
+	during := time.Date(2025, 4, 22, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		material rules.Material
+		point    string
+		at       time.Time
+		points   amount.Points
+		expected string
+		bonus    amount.Points
+	}{
+		{name: "double PET", material: rules.MaterialPET, at: during, points: 30 * amount.Point, expected: "earth", bonus: 30 * amount.Point},
+		{name: "other material", material: rules.MaterialPP, at: during, points: 30 * amount.Point},
+		{name: "ends_at is exclusive", material: rules.MaterialPET, at: time.Date(2025, 4, 27, 0, 0, 0, 0, time.UTC), points: 30 * amount.Point},
+		{name: "collection point ignores case", material: rules.MaterialPP, point: "q1-benthanh", at: during, points: 30 * amount.Point, expected: "d1", bonus: 5 * amount.Point},
+		{name: "larger bonus wins", material: rules.MaterialPET, point: "Q1-BenThanh", at: during, points: 2 * amount.Point, expected: "d1", bonus: 5 * amount.Point},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			c, bonus, ok, err := Best([]Campaign{earthWeek, districtOne}, testCase.material, testCase.point, testCase.at, testCase.points)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (testCase.expected != "") || c.ID != testCase.expected || bonus != testCase.bonus {
+				t.Errorf("Expected %q with %v bonus, but got %q with %v (ok=%v)", testCase.expected, testCase.bonus, c.ID, bonus, ok)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		edit  func(c *Campaign)
+		valid bool
+	}{
+		{name: "valid", edit: func(c *Campaign) {}, valid: true},
+		{name: "material is normalised", edit: func(c *Campaign) { c.Materials = []rules.Material{"pet"} }, valid: true},
+		{name: "unknown material", edit: func(c *Campaign) { c.Materials = []rules.Material{"GLASS"} }},
+		{name: "ends before it starts", edit: func(c *Campaign) { c.EndsAt = c.StartsAt }},
+		{name: "no reward", edit: func(c *Campaign) { c.MultiplierPercent = 100 }},
+		{name: "multiplier below 100", edit: func(c *Campaign) { c.MultiplierPercent = 50 }},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			c := earthWeek
+			c.Materials = append([]rules.Material(nil), earthWeek.Materials...)
+			testCase.edit(&c)
+			if err := c.Validate(); (err == nil) != testCase.valid {
+				t.Errorf("Expected valid=%v, but got %v", testCase.valid, err)
+			}
+		})
+	}
+}
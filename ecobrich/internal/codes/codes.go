@@ -0,0 +1,182 @@
+// Package codes manages pools of unique voucher codes. Each code is its own
+// item, PK=CODE#<code>, so codes are unique across every voucher and a
+// partner can look one up directly. Unclaimed codes carry AvailableIn, the
+// SK of their voucher definition, which feeds the sparse PoolIndex GSI;
+// claiming a code removes the attribute and with it the index entry.
+package codes
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// PoolIndex is the GSI over AvailableIn declared on PlasticDbTable.
+	PoolIndex = "PoolIndex"
+
+	StatusAvailable = "available"
+	StatusClaimed   = "claimed"
+	StatusUsed      = "used" // Consumed at a partner shop
+
+	// MaxLength keeps uploaded codes printable on a receipt.
+	MaxLength = 64
+	// bodyLength is the random part of a generated code, before the check character.
+	bodyLength = 9
+)
+
+// alphabet is Crockford base32, which has no I, L, O or U to misread.
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ErrInvalid = errors.New("codes: invalid code")
+
+// Normalize is the stored form of a code typed by a user or partner.
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Check validates an uploaded code.
+func Check(code string) error {
+	if code == "" || len(code) > MaxLength {
+		return fmt.Errorf("%w: must be 1 to %d characters", ErrInvalid, MaxLength)
+	}
+	for _, r := range code {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("%w %q: only letters, digits and - are allowed", ErrInvalid, code)
+		}
+	}
+	return nil
+}
+
+// Generate returns n random codes of the form PREFIX-XXXXXXXXXC, where C is
+// a Luhn mod 32 check character over the random part. The check character
+// catches typos before a lookup.
+func Generate(prefix string, n int) ([]string, error) {
+	prefix = Normalize(prefix)
+	out := make([]string, n)
+	for i := range out {
+		body := make([]byte, bodyLength)
+		for j := range body {
+			k, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return nil, err
+			}
+			body[j] = alphabet[k.Int64()]
+		}
+		c, _ := checkChar(string(body))
+		out[i] = string(body) + string(c)
+		if prefix != "" {
+			out[i] = prefix + "-" + out[i]
+		}
+	}
+	return out, nil
+}
+
+// ValidCheck reports whether a generated code has a correct check character.
+// Uploaded codes have no check character and are only found by lookup.
+func ValidCheck(code string) bool {
+	code = Normalize(code)
+	if i := strings.LastIndexByte(code, '-'); i >= 0 {
+		code = code[i+1:]
+	}
+	if len(code) != bodyLength+1 {
+		return false
+	}
+	c, err := checkChar(code[:bodyLength])
+	return err == nil && c == code[bodyLength]
+}
+
+func checkChar(body string) (byte, error) {
+	n := len(alphabet)
+	factor, sum := 2, 0
+	for i := len(body) - 1; i >= 0; i-- {
+		v := strings.IndexByte(alphabet, body[i])
+		if v < 0 {
+			return 0, ErrInvalid
+		}
+		addend := factor * v
+		sum += addend/n + addend%n
+		factor = 3 - factor
+	}
+	return alphabet[(n-sum%n)%n], nil
+}
+
+// Key is the primary key of a code item.
+func Key(code string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "CODE#" + code},
+		"SK": &types.AttributeValueMemberS{Value: "CODE"},
+	}
+}
+
+// PutItem adds an unclaimed code to the pool of voucherRef. It fails its
+// condition if the code already exists anywhere.
+func PutItem(table, voucherRef, batchID, code, now string) types.TransactWriteItem {
+	item := Key(code)
+	item["Type"] = &types.AttributeValueMemberS{Value: "VOUCHER_CODE"}
+	item["Code"] = &types.AttributeValueMemberS{Value: code}
+	item["VoucherRef"] = &types.AttributeValueMemberS{Value: voucherRef}
+	item["AvailableIn"] = &types.AttributeValueMemberS{Value: voucherRef}
+	item["Status"] = &types.AttributeValueMemberS{Value: StatusAvailable}
+	item["BatchID"] = &types.AttributeValueMemberS{Value: batchID}
+	item["CreatedAt"] = &types.AttributeValueMemberS{Value: now}
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	}}
+}
+
+// ClaimItem hands code to a user inside the redeem transaction. The
+// condition fails if another redemption claimed it first.
+func ClaimItem(table, voucherRef, code, userID, userVoucherSK, now string) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:           aws.String(table),
+		Key:                 Key(code),
+		UpdateExpression:    aws.String("SET #status = :claimed, UserID = :user, UserVoucherSK = :uv, ClaimedAt = :t REMOVE AvailableIn"),
+		ConditionExpression: aws.String("AvailableIn = :ref"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":claimed": &types.AttributeValueMemberS{Value: StatusClaimed},
+			":user":    &types.AttributeValueMemberS{Value: userID},
+			":uv":      &types.AttributeValueMemberS{Value: userVoucherSK},
+			":t":       &types.AttributeValueMemberS{Value: now},
+			":ref":     &types.AttributeValueMemberS{Value: voucherRef},
+		},
+	}}
+}
+
+// Available returns up to limit unclaimed codes of voucherRef. The index is
+// eventually consistent, so a code may already be gone; ClaimItem's
+// condition is what guarantees a code is handed out once.
+func Available(ctx context.Context, api dynamodb.QueryAPIClient, table, voucherRef string, limit int32) ([]string, error) {
+	out, err := api.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		IndexName:              aws.String(PoolIndex),
+		KeyConditionExpression: aws.String("AvailableIn = :ref"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ref": &types.AttributeValueMemberS{Value: voucherRef},
+		},
+		ProjectionExpression: aws.String("Code"),
+		Limit:                aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, item := range out.Items {
+		if v, ok := item["Code"].(*types.AttributeValueMemberS); ok {
+			list = append(list, v.Value)
+		}
+	}
+	return list, nil
+}
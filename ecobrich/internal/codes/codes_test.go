@@ -0,0 +1,66 @@
+package codes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	list, err := Generate("eco", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, c := range list {
+		if !strings.HasPrefix(c, "ECO-") || len(c) != len("ECO-")+bodyLength+1 {
+			t.Errorf("Unexpected code %q", c)
+		}
+		if !ValidCheck(c) {
+			t.Errorf("Expected %q to pass its check", c)
+		}
+		if err := Check(c); err != nil {
+			t.Errorf("Expected %q to be a valid code, but got %v", c, err)
+		}
+		seen[c] = true
+	}
+	if len(seen) != len(list) {
+		t.Errorf("Expected %d distinct codes, but got %d", len(list), len(seen))
+	}
+}
+
+func TestValidCheck(t *testing.T) {
+	list, _ := Generate("", 1)
+	code := list[0]
+
+	// Any single mistyped character is caught
+	typo := []byte(code)
+	typo[3] = alphabet[(strings.IndexByte(alphabet, typo[3])+1)%len(alphabet)]
+
+	testCases := []struct {
+		name     string
+		code     string
+		expected bool
+	}{
+		{"generated", code, true},
+		{"lower case", strings.ToLower(code), true},
+		{"typo", string(typo), false},
+		{"too short", code[:5], false},
+		{"not in the alphabet", "IIIIIIIIII", false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := ValidCheck(testCase.code); got != testCase.expected {
+				t.Errorf("ValidCheck(%q): expected %v, but got %v", testCase.code, testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, bad := range []string{"", "HAS SPACE", "ÜBER", strings.Repeat("A", MaxLength+1)} {
+		if err := Check(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Check(%q): expected ErrInvalid, but got %v", bad, err)
+		}
+	}
+}
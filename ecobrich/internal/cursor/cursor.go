@@ -0,0 +1,73 @@
+// Package cursor turns a DynamoDB LastEvaluatedKey into an opaque string
+// that list endpoints hand out as next_cursor, and back.
+package cursor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalid is returned for a cursor that was not produced by Encode.
+var ErrInvalid = errors.New("cursor: invalid cursor")
+
+// attr is the JSON form of a key attribute. Table and index keys are only
+// ever strings or numbers.
+type attr struct {
+	S *string `json:"s,omitempty"`
+	N *string `json:"n,omitempty"`
+}
+
+// Encode returns "" when there is no next page.
+func Encode(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	m := make(map[string]attr, len(key))
+	for name, v := range key {
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			m[name] = attr{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			m[name] = attr{N: &v.Value}
+		default:
+			return "", fmt.Errorf("cursor: unsupported key attribute %s of type %T", name, v)
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Decode returns nil for an empty cursor so the result can be passed
+// straight to ExclusiveStartKey.
+func Decode(s string) (map[string]types.AttributeValue, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var m map[string]attr
+	if err := json.Unmarshal(b, &m); err != nil || len(m) == 0 {
+		return nil, ErrInvalid
+	}
+	key := make(map[string]types.AttributeValue, len(m))
+	for name, v := range m {
+		switch {
+		case v.S != nil:
+			key[name] = &types.AttributeValueMemberS{Value: *v.S}
+		case v.N != nil:
+			key[name] = &types.AttributeValueMemberN{Value: *v.N}
+		default:
+			return nil, ErrInvalid
+		}
+	}
+	return key, nil
+}
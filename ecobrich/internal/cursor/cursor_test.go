@@ -0,0 +1,47 @@
+package cursor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRoundTrip(t *testing.T) {
+	key := map[string]types.AttributeValue{
+		"PK":        &types.AttributeValueMemberS{Value: "USER#abc"},
+		"SK":        &types.AttributeValueMemberS{Value: "TRANS#2024-05-01T10:00:00Z"},
+		"Status":    &types.AttributeValueMemberS{Value: "pending"},
+		"CreatedAt": &types.AttributeValueMemberS{Value: "2024-05-01T10:00:00Z"},
+		"Points":    &types.AttributeValueMemberN{Value: "120"},
+	}
+
+	s, err := Encode(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, key) {
+		t.Errorf("Expected %v, but got %v", key, got)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	if s, err := Encode(nil); s != "" || err != nil {
+		t.Errorf("Expected empty cursor, but got %q, %v", s, err)
+	}
+	if key, err := Decode(""); key != nil || err != nil {
+		t.Errorf("Expected nil key, but got %v, %v", key, err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, s := range []string{"%%%", "bm90IGpzb24", "e30", "eyJQSyI6e319"} {
+		if _, err := Decode(s); err != ErrInvalid {
+			t.Errorf("Decode(%q): expected ErrInvalid, but got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Package dberr classifies DynamoDB errors so handlers can map a failed
+// condition to a client error instead of a 500.
+package dberr
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const conditionalCheckFailed = "ConditionalCheckFailed"
+
+// ConditionFailed reports whether err was caused by a ConditionExpression,
+// either on a single write or on any item of a transaction.
+func ConditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return true
+	}
+	return len(ConditionFailedItems(err)) > 0
+}
+
+// ConditionFailedAt reports whether the transaction item at index failed its
+// ConditionExpression.
+func ConditionFailedAt(err error, index int) bool {
+	for _, i := range ConditionFailedItems(err) {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// ConditionFailedItems returns the indexes of the transaction items whose
+// ConditionExpression failed.
+func ConditionFailedItems(err error) []int {
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		return nil
+	}
+	var failed []int
+	for i, r := range tce.CancellationReasons {
+		if r.Code != nil && *r.Code == conditionalCheckFailed {
+			failed = append(failed, i)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,46 @@
+package dberr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestConditionFailed(t *testing.T) {
+	canceled := &types.TransactionCanceledException{
+		CancellationReasons: []types.CancellationReason{
+			{Code: aws.String("None")},
+			{Code: aws.String("ConditionalCheckFailed")},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		err      error
+		failed   bool
+		failedAt []int
+	}{
+		{name: "nil", err: nil},
+		{name: "other error", err: errors.New("throttled")},
+		{name: "single write", err: &types.ConditionalCheckFailedException{}, failed: true},
+		{name: "transaction", err: canceled, failed: true, failedAt: []int{1}},
+		{name: "wrapped transaction", err: fmt.Errorf("redeem: %w", canceled), failed: true, failedAt: []int{1}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := ConditionFailed(testCase.err); got != testCase.failed {
+				t.Errorf("Expected ConditionFailed %v, but got %v", testCase.failed, got)
+			}
+			if got := ConditionFailedItems(testCase.err); fmt.Sprint(got) != fmt.Sprint(testCase.failedAt) {
+				t.Errorf("Expected failed items %v, but got %v", testCase.failedAt, got)
+			}
+			if ConditionFailedAt(testCase.err, 0) {
+				t.Errorf("Item 0 never fails in these cases")
+			}
+		})
+	}
+}
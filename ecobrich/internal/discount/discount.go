@@ -0,0 +1,284 @@
+// Package discount models what a voucher takes off an order: a percentage,
+// a fixed amount in VND or free shipping, with an optional cap, a minimum
+// order value and a list of eligible product categories. Definitions keep
+// the rule in a DiscountRule map next to the display string in Discount,
+// and redemption copies both into the USER_VOUCHER.
+package discount
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type Type string
+
+const (
+	Percent      Type = "percent"
+	Fixed        Type = "fixed"
+	FreeShipping Type = "free_shipping"
+)
+
+// Amounts are whole VND, the smallest unit in use.
+type Discount struct {
+	Type        Type     `json:"type"`
+	Percent     int64    `json:"percent,omitempty"`       // 1 to 100, percent only
+	AmountVND   int64    `json:"amount_vnd,omitempty"`    // fixed only
+	MaxVND      int64    `json:"max_vnd,omitempty"`       // Cap on the discount, 0 is uncapped
+	MinOrderVND int64    `json:"min_order_vnd,omitempty"` // Subtotal the order must reach
+	Categories  []string `json:"categories,omitempty"`    // Eligible product categories, empty is all
+}
+
+var (
+	ErrMinOrder   = errors.New("order is below the minimum value")
+	ErrNoEligible = errors.New("no item in the order is eligible")
+	ErrLabel      = errors.New("discount must look like 10%, 50000đ or free shipping")
+)
+
+// Validate checks a rule submitted by an admin and normalises its
+// categories.
+func (d *Discount) Validate() error {
+	switch d.Type {
+	case Percent:
+		if d.Percent < 1 || d.Percent > 100 {
+			return errors.New("percent must be between 1 and 100")
+		}
+		if d.AmountVND != 0 {
+			return errors.New("amount_vnd only applies to fixed discounts")
+		}
+	case Fixed:
+		if d.AmountVND <= 0 {
+			return errors.New("amount_vnd must be positive")
+		}
+		if d.Percent != 0 {
+			return errors.New("percent only applies to percent discounts")
+		}
+	case FreeShipping:
+		if d.Percent != 0 || d.AmountVND != 0 {
+			return errors.New("free_shipping takes no percent or amount_vnd")
+		}
+	default:
+		return errors.New("type must be percent, fixed or free_shipping")
+	}
+	if d.MaxVND < 0 || d.MinOrderVND < 0 {
+		return errors.New("max_vnd and min_order_vnd must not be negative")
+	}
+	seen := map[string]bool{}
+	var categories []string
+	for _, c := range d.Categories {
+		c = NormalizeCategory(c)
+		if c == "" {
+			return errors.New("categories must not be empty")
+		}
+		if !seen[c] {
+			seen[c] = true
+			categories = append(categories, c)
+		}
+	}
+	d.Categories = categories
+	return nil
+}
+
+// NormalizeCategory makes category matching case-insensitive.
+func NormalizeCategory(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// Parse reads the free-text Discount admins used to type, so definitions
+// created without a rule still get one: "10%", "50000", "50.000đ",
+// "50,000 VND" or "free shipping".
+func Parse(s string) (Discount, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "free shipping", "freeship", "free_shipping":
+		return Discount{Type: FreeShipping}, nil
+	}
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		n, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+		if err != nil {
+			return Discount{}, ErrLabel
+		}
+		d := Discount{Type: Percent, Percent: n}
+		return d, d.Validate()
+	}
+	for _, suffix := range []string{"vnd", "đ", "d"} {
+		if p, ok := strings.CutSuffix(s, suffix); ok {
+			s = strings.TrimSpace(p)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.NewReplacer(".", "", ",", "").Replace(s), 10, 64)
+	if err != nil {
+		return Discount{}, ErrLabel
+	}
+	d := Discount{Type: Fixed, AmountVND: n}
+	return d, d.Validate()
+}
+
+// Label is the display form stored in Discount.
+func (d Discount) Label() string {
+	switch d.Type {
+	case Percent:
+		return fmt.Sprintf("%d%%", d.Percent)
+	case Fixed:
+		return formatVND(d.AmountVND)
+	case FreeShipping:
+		return "Free shipping"
+	}
+	return ""
+}
+
+// Line is one product of a cart. Category is matched case-insensitively.
+type Line struct {
+	Category string `json:"category"`
+	UnitVND  int64  `json:"unit_vnd"`
+	Quantity int64  `json:"quantity"`
+}
+
+type Cart struct {
+	Lines       []Line `json:"lines"`
+	ShippingVND int64  `json:"shipping_vnd"`
+}
+
+// Validate rejects carts no real order could produce.
+func (c Cart) Validate() error {
+	if len(c.Lines) == 0 {
+		return errors.New("cart has no lines")
+	}
+	for _, l := range c.Lines {
+		if l.UnitVND < 0 || l.Quantity <= 0 {
+			return errors.New("cart lines need a non-negative unit_vnd and a positive quantity")
+		}
+	}
+	if c.ShippingVND < 0 {
+		return errors.New("shipping_vnd must not be negative")
+	}
+	return nil
+}
+
+// Subtotal is the value of the products, without shipping.
+func (c Cart) Subtotal() int64 {
+	var total int64
+	for _, l := range c.Lines {
+		total += l.UnitVND * l.Quantity
+	}
+	return total
+}
+
+// Apply returns the VND the voucher takes off the cart. The minimum order
+// is checked against the whole subtotal; the discount itself only covers
+// eligible lines, or shipping. Percentages round down, and the result never
+// exceeds the cap or what it applies to.
+func (d Discount) Apply(c Cart) (int64, error) {
+	if err := c.Validate(); err != nil {
+		return 0, err
+	}
+	if c.Subtotal() < d.MinOrderVND {
+		return 0, ErrMinOrder
+	}
+
+	var base int64
+	eligible := false
+	for _, l := range c.Lines {
+		if d.eligible(l.Category) {
+			base += l.UnitVND * l.Quantity
+			eligible = true
+		}
+	}
+	if !eligible {
+		return 0, ErrNoEligible
+	}
+
+	var off int64
+	switch d.Type {
+	case Percent:
+		off = base * d.Percent / 100
+	case Fixed:
+		off = d.AmountVND
+	case FreeShipping:
+		base, off = c.ShippingVND, c.ShippingVND
+	default:
+		return 0, fmt.Errorf("discount: unknown type %q", d.Type)
+	}
+	if d.MaxVND > 0 {
+		off = min(off, d.MaxVND)
+	}
+	return min(off, base), nil
+}
+
+func (d Discount) eligible(category string) bool {
+	if len(d.Categories) == 0 {
+		return true
+	}
+	category = NormalizeCategory(category)
+	for _, c := range d.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Attr encodes the rule as a DynamoDB map.
+func (d Discount) Attr() types.AttributeValue {
+	m := map[string]types.AttributeValue{
+		"Type": &types.AttributeValueMemberS{Value: string(d.Type)},
+	}
+	for name, v := range map[string]int64{
+		"Percent":     d.Percent,
+		"AmountVND":   d.AmountVND,
+		"MaxVND":      d.MaxVND,
+		"MinOrderVND": d.MinOrderVND,
+	} {
+		if v != 0 {
+			m[name] = &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+		}
+	}
+	if len(d.Categories) > 0 {
+		m["Categories"] = &types.AttributeValueMemberSS{Value: d.Categories}
+	}
+	return &types.AttributeValueMemberM{Value: m}
+}
+
+// FromItem reads item[name]. ok is false when the item has no rule.
+func FromItem(item map[string]types.AttributeValue, name string) (d Discount, ok bool, err error) {
+	m, ok := item[name].(*types.AttributeValueMemberM)
+	if !ok {
+		return Discount{}, false, nil
+	}
+	if v, ok := m.Value["Type"].(*types.AttributeValueMemberS); ok {
+		d.Type = Type(v.Value)
+	}
+	for attr, field := range map[string]*int64{
+		"Percent":     &d.Percent,
+		"AmountVND":   &d.AmountVND,
+		"MaxVND":      &d.MaxVND,
+		"MinOrderVND": &d.MinOrderVND,
+	} {
+		if v, ok := m.Value[attr].(*types.AttributeValueMemberN); ok {
+			if *field, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+				return Discount{}, true, fmt.Errorf("discount: invalid %s %q", attr, v.Value)
+			}
+		}
+	}
+	if v, ok := m.Value["Categories"].(*types.AttributeValueMemberSS); ok {
+		d.Categories = v.Value
+	}
+	return d, true, nil
+}
+
+// formatVND groups thousands with dots, as Vietnamese prices are written.
+func formatVND(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + "đ"
+}
@@ -0,0 +1,136 @@
+package discount
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected Discount
+		err      bool
+	}{
+		{in: "8%", expected: Discount{Type: Percent, Percent: 8}},
+		{in: " 15 % ", expected: Discount{Type: Percent, Percent: 15}},
+		{in: "50000", expected: Discount{Type: Fixed, AmountVND: 50000}},
+		{in: "50.000đ", expected: Discount{Type: Fixed, AmountVND: 50000}},
+		{in: "50,000 VND", expected: Discount{Type: Fixed, AmountVND: 50000}},
+		{in: "Free shipping", expected: Discount{Type: FreeShipping}},
+		{in: "150%", err: true},
+		{in: "Giảm giá", err: true},
+		{in: "", err: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.in, func(t *testing.T) {
+			got, err := Parse(testCase.in)
+			if (err != nil) != testCase.err {
+				t.Fatalf("Expected error %v, but got %v", testCase.err, err)
+			}
+			if err == nil && !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("Expected %+v, but got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestLabel(t *testing.T) {
+	testCases := []struct {
+		discount Discount
+		expected string
+	}{
+		{Discount{Type: Percent, Percent: 10}, "10%"},
+		{Discount{Type: Fixed, AmountVND: 1250000}, "1.250.000đ"},
+		{Discount{Type: Fixed, AmountVND: 500}, "500đ"},
+		{Discount{Type: FreeShipping}, "Free shipping"},
+	}
+	for _, testCase := range testCases {
+		if got := testCase.discount.Label(); got != testCase.expected {
+			t.Errorf("Label(%+v): expected %q, but got %q", testCase.discount, testCase.expected, got)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	cart := Cart{
+		Lines: []Line{
+			{Category: "Building Materials", UnitVND: 100000, Quantity: 2},
+			{Category: "home", UnitVND: 55555, Quantity: 1},
+		},
+		ShippingVND: 30000,
+	}
+
+	testCases := []struct {
+		name     string
+		discount Discount
+		expected int64
+		err      error
+	}{
+		{name: "percent of everything rounds down", discount: Discount{Type: Percent, Percent: 10}, expected: 25555},
+		{name: "percent capped", discount: Discount{Type: Percent, Percent: 50, MaxVND: 100000}, expected: 100000},
+		{name: "percent of one category", discount: Discount{Type: Percent, Percent: 10, Categories: []string{"building materials"}}, expected: 20000},
+		{name: "fixed", discount: Discount{Type: Fixed, AmountVND: 50000}, expected: 50000},
+		{name: "fixed never exceeds eligible lines", discount: Discount{Type: Fixed, AmountVND: 80000, Categories: []string{"home"}}, expected: 55555},
+		{name: "free shipping", discount: Discount{Type: FreeShipping}, expected: 30000},
+		{name: "free shipping capped", discount: Discount{Type: FreeShipping, MaxVND: 20000}, expected: 20000},
+		{name: "minimum order met", discount: Discount{Type: Fixed, AmountVND: 10000, MinOrderVND: 255555}, expected: 10000},
+		{name: "minimum order not met", discount: Discount{Type: Fixed, AmountVND: 10000, MinOrderVND: 300000}, err: ErrMinOrder},
+		{name: "no eligible category", discount: Discount{Type: Percent, Percent: 10, Categories: []string{"garden"}}, err: ErrNoEligible},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := testCase.discount.Apply(cart)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected error %v, but got %v", testCase.err, err)
+			}
+			if got != testCase.expected {
+				t.Errorf("Expected %d, but got %d", testCase.expected, got)
+			}
+		})
+	}
+
+	if _, err := (Discount{Type: Percent, Percent: 10}).Apply(Cart{}); err == nil {
+		t.Error("Expected an error for an empty cart")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Discount{Type: Percent, Percent: 10, Categories: []string{" Home ", "home", "Garden"}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if !reflect.DeepEqual(valid.Categories, []string{"home", "garden"}) {
+		t.Errorf("Expected normalised categories, but got %v", valid.Categories)
+	}
+
+	for _, d := range []Discount{
+		{Type: "bogo"},
+		{Type: Percent},
+		{Type: Percent, Percent: 10, AmountVND: 5000},
+		{Type: Fixed},
+		{Type: FreeShipping, Percent: 5},
+		{Type: Fixed, AmountVND: 5000, MaxVND: -1},
+		{Type: Fixed, AmountVND: 5000, Categories: []string{" "}},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("Validate(%+v): expected an error", d)
+		}
+	}
+}
+
+func TestAttrRoundTrip(t *testing.T) {
+	d := Discount{Type: Percent, Percent: 15, MaxVND: 50000, MinOrderVND: 200000, Categories: []string{"home"}}
+	got, ok, err := FromItem(map[string]types.AttributeValue{"DiscountRule": d.Attr()}, "DiscountRule")
+	if err != nil || !ok {
+		t.Fatalf("Expected a rule, but got ok=%v err=%v", ok, err)
+	}
+	if !reflect.DeepEqual(got, d) {
+		t.Errorf("Expected %+v, but got %+v", d, got)
+	}
+	if _, ok, _ := FromItem(map[string]types.AttributeValue{}, "DiscountRule"); ok {
+		t.Error("Expected no rule on an item without one")
+	}
+}
@@ -0,0 +1,160 @@
+// Package eligibility restricts vouchers to some members: those with enough
+// lifetime plastic, members of a segment such as a partner school, or
+// members with a given number of approved donations, e.g. first-time
+// donors. A definition stores its rule in the Eligibility map; tiers keep
+// their own MinTier check.
+package eligibility
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+)
+
+// Rule is met when every condition set on it is met.
+type Rule struct {
+	MinKg        amount.Grams `json:"min_kg,omitempty"`        // Lifetime approved plastic
+	Segments     []string     `json:"segments,omitempty"`      // Member of at least one
+	MinDonations int64        `json:"min_donations,omitempty"` // Approved donations
+	MaxDonations *int64       `json:"max_donations,omitempty"` // 1, with min_donations 1, is first-time donors
+	// ShowLocked lists the voucher to ineligible members, locked with the
+	// reason, instead of hiding it
+	ShowLocked bool `json:"show_locked,omitempty"`
+}
+
+// Member is what a rule is checked against.
+type Member struct {
+	SignedIn  bool
+	TotalKg   amount.Grams
+	Segments  []string
+	Donations int
+}
+
+// Validate checks a rule submitted by an admin and normalises its segments.
+func (r *Rule) Validate() error {
+	if r.MinKg < 0 || r.MinDonations < 0 {
+		return errors.New("min_kg and min_donations must not be negative")
+	}
+	if r.MaxDonations != nil && *r.MaxDonations < r.MinDonations {
+		return errors.New("max_donations must not be below min_donations")
+	}
+	var segments []string
+	seen := map[string]bool{}
+	for _, s := range r.Segments {
+		s = NormalizeSegment(s)
+		if s == "" {
+			return errors.New("segments must not be empty")
+		}
+		if !seen[s] {
+			seen[s] = true
+			segments = append(segments, s)
+		}
+	}
+	r.Segments = segments
+	return nil
+}
+
+// IsZero reports whether the rule restricts nothing.
+func (r Rule) IsZero() bool {
+	return r.MinKg == 0 && len(r.Segments) == 0 && r.MinDonations == 0 && r.MaxDonations == nil
+}
+
+// NormalizeSegment makes segment names case-insensitive, e.g. "school:nguyen-du".
+func NormalizeSegment(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// Check returns "" when m is eligible, otherwise the reason shown to them.
+func (r Rule) Check(m Member) string {
+	if r.IsZero() {
+		return ""
+	}
+	if !m.SignedIn {
+		return "Sign in to check whether this voucher is for you"
+	}
+	if m.TotalKg < r.MinKg {
+		return fmt.Sprintf("This voucher is for members who have recycled at least %s kg", r.MinKg)
+	}
+	if len(r.Segments) > 0 && !r.inSegment(m.Segments) {
+		return "This voucher is reserved for members of a partner group"
+	}
+	if int64(m.Donations) < r.MinDonations {
+		return fmt.Sprintf("This voucher is for members with at least %d approved donations", r.MinDonations)
+	}
+	if r.MaxDonations != nil && int64(m.Donations) > *r.MaxDonations {
+		if *r.MaxDonations <= 1 {
+			return "This voucher is for first-time donors"
+		}
+		return fmt.Sprintf("This voucher is for members with at most %d approved donations", *r.MaxDonations)
+	}
+	return ""
+}
+
+func (r Rule) inSegment(segments []string) bool {
+	for _, s := range segments {
+		for _, want := range r.Segments {
+			if NormalizeSegment(s) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Attr encodes the rule as a DynamoDB map.
+func (r Rule) Attr() types.AttributeValue {
+	m := map[string]types.AttributeValue{}
+	if r.MinKg != 0 {
+		m["MinKg"] = r.MinKg.Attr()
+	}
+	if len(r.Segments) > 0 {
+		m["Segments"] = &types.AttributeValueMemberSS{Value: r.Segments}
+	}
+	if r.MinDonations != 0 {
+		m["MinDonations"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(r.MinDonations, 10)}
+	}
+	if r.MaxDonations != nil {
+		m["MaxDonations"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*r.MaxDonations, 10)}
+	}
+	if r.ShowLocked {
+		m["ShowLocked"] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+	return &types.AttributeValueMemberM{Value: m}
+}
+
+// FromItem reads item[name]; an item without it is open to everyone.
+func FromItem(item map[string]types.AttributeValue, name string) (Rule, error) {
+	var r Rule
+	m, ok := item[name].(*types.AttributeValueMemberM)
+	if !ok {
+		return r, nil
+	}
+	var err error
+	if r.MinKg, err = amount.GramsAttr(m.Value, "MinKg"); err != nil {
+		return Rule{}, fmt.Errorf("eligibility: %w", err)
+	}
+	if v, ok := m.Value["Segments"].(*types.AttributeValueMemberSS); ok {
+		r.Segments = v.Value
+	}
+	if v, ok := m.Value["MinDonations"].(*types.AttributeValueMemberN); ok {
+		if r.MinDonations, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+			return Rule{}, fmt.Errorf("eligibility: invalid MinDonations %q", v.Value)
+		}
+	}
+	if v, ok := m.Value["MaxDonations"].(*types.AttributeValueMemberN); ok {
+		n, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return Rule{}, fmt.Errorf("eligibility: invalid MaxDonations %q", v.Value)
+		}
+		r.MaxDonations = &n
+	}
+	if v, ok := m.Value["ShowLocked"].(*types.AttributeValueMemberBOOL); ok {
+		r.ShowLocked = v.Value
+	}
+	return r, nil
+}
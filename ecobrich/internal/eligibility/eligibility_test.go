@@ -0,0 +1,78 @@
+package eligibility
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+)
+
+func TestCheck(t *testing.T) {
+	one := int64(1)
+	firstTime := Rule{MinDonations: 1, MaxDonations: &one}
+	member := Member{SignedIn: true, TotalKg: 60 * amount.Kg, Segments: []string{"School:Nguyen-Du"}, Donations: 3}
+
+	testCases := []struct {
+		name     string
+		rule     Rule
+		member   Member
+		eligible bool
+	}{
+		{name: "open to everyone", rule: Rule{}, member: Member{}, eligible: true},
+		{name: "guest", rule: Rule{MinKg: 50 * amount.Kg}, member: Member{}, eligible: false},
+		{name: "enough kg", rule: Rule{MinKg: 50 * amount.Kg}, member: member, eligible: true},
+		{name: "not enough kg", rule: Rule{MinKg: 100 * amount.Kg}, member: member, eligible: false},
+		{name: "in segment", rule: Rule{Segments: []string{"school:nguyen-du", "school:le-loi"}}, member: member, eligible: true},
+		{name: "outside segment", rule: Rule{Segments: []string{"school:le-loi"}}, member: member, eligible: false},
+		{name: "first-time donor", rule: firstTime, member: Member{SignedIn: true, Donations: 1}, eligible: true},
+		{name: "never donated", rule: firstTime, member: Member{SignedIn: true}, eligible: false},
+		{name: "regular donor", rule: firstTime, member: member, eligible: false},
+		{name: "every condition", rule: Rule{MinKg: 50 * amount.Kg, Segments: []string{"school:nguyen-du"}, MinDonations: 2}, member: member, eligible: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			reason := testCase.rule.Check(testCase.member)
+			if (reason == "") != testCase.eligible {
+				t.Errorf("Expected eligible %v, but got reason %q", testCase.eligible, reason)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	r := Rule{Segments: []string{" School:A ", "school:a"}}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Segments, []string{"school:a"}) {
+		t.Errorf("Expected normalised segments, but got %v", r.Segments)
+	}
+
+	zero := int64(0)
+	for _, r := range []Rule{
+		{MinKg: -1},
+		{MinDonations: 2, MaxDonations: &zero},
+		{Segments: []string{""}},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("Validate(%+v): expected an error", r)
+		}
+	}
+}
+
+func TestAttrRoundTrip(t *testing.T) {
+	one := int64(1)
+	r := Rule{MinKg: 1500, Segments: []string{"school:a"}, MinDonations: 1, MaxDonations: &one, ShowLocked: true}
+	got, err := FromItem(map[string]types.AttributeValue{"Eligibility": r.Attr()}, "Eligibility")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("Expected %+v, but got %+v", r, got)
+	}
+	if got, _ := FromItem(map[string]types.AttributeValue{}, "Eligibility"); !got.IsZero() {
+		t.Errorf("Expected an open rule, but got %+v", got)
+	}
+}
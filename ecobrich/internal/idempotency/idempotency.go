@@ -0,0 +1,232 @@
+// Package idempotency lets mutating endpoints honour an Idempotency-Key
+// header. The first request with a key runs normally and its response is
+// stored for TTL; a retry with the same key gets that response replayed
+// instead of writing a second TRANS# or REDEEM# item.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/dberr"
+)
+
+const (
+	// HeaderName is the request header carrying the client's key.
+	HeaderName = "Idempotency-Key"
+	// ReplayHeader is set on responses served from a stored record.
+	ReplayHeader = "Idempotent-Replayed"
+
+	// TTL is how long a key is remembered. The table's TTL attribute is
+	// ExpiresAtEpoch.
+	TTL = 24 * time.Hour
+	// lockTimeout frees a key whose first request died mid-flight.
+	lockTimeout = 30 * time.Second
+
+	maxKeyLength = 255
+
+	stateInProgress = "in_progress"
+	stateCompleted  = "completed"
+)
+
+// Store is the subset of the DynamoDB client used for records.
+type Store interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// Handler is the signature of every API Gateway Lambda in this repo.
+type Handler func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Middleware wraps a handler so POST, PUT, PATCH and DELETE requests carrying
+// an Idempotency-Key are executed at most once per caller and key.
+func Middleware(store Store, table string, next Handler) Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		switch request.HTTPMethod {
+		case "POST", "PUT", "PATCH", "DELETE":
+		default:
+			return next(ctx, request)
+		}
+		key := KeyFromHeaders(request.Headers)
+		if key == "" {
+			return next(ctx, request)
+		}
+		if len(key) > maxKeyLength {
+			return message(400, "Idempotency-Key is too long"), nil
+		}
+
+		// Keys are per caller and per endpoint, so two users can't collide
+		sub := ""
+		if claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{}); ok {
+			sub, _ = claims["sub"].(string)
+		}
+		scope := strings.Join([]string{sub, request.HTTPMethod, request.Path}, "#")
+
+		return Do(ctx, store, table, scope, key, Hash(request.Body), func() (events.APIGatewayProxyResponse, error) {
+			return next(ctx, request)
+		})
+	}
+}
+
+// KeyFromHeaders finds the Idempotency-Key header regardless of casing.
+func KeyFromHeaders(headers map[string]string) string {
+	for name, v := range headers {
+		if strings.EqualFold(name, HeaderName) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// Hash fingerprints a request body so a key reused for a different payload
+// is rejected instead of replaying an unrelated response.
+func Hash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Do runs fn once for scope and key.
+func Do(ctx context.Context, store Store, table, scope, key, requestHash string, fn func() (events.APIGatewayProxyResponse, error)) (events.APIGatewayProxyResponse, error) {
+	now := time.Now()
+	recordKey := map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "IDEMPOTENCY#" + scope + "#" + key},
+		"SK": &types.AttributeValueMemberS{Value: "IDEMPOTENCY"},
+	}
+
+	// 1. Claim the key, or take over a lock abandoned by a crashed request
+	item := map[string]types.AttributeValue{
+		"State":          &types.AttributeValueMemberS{Value: stateInProgress},
+		"RequestHash":    &types.AttributeValueMemberS{Value: requestHash},
+		"LockedUntil":    epoch(now.Add(lockTimeout)),
+		"ExpiresAtEpoch": epoch(now.Add(TTL)),
+		"CreatedAt":      &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+	}
+	for k, v := range recordKey {
+		item[k] = v
+	}
+	_, err := store.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(table),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(PK) OR (#state = :inProgress AND LockedUntil < :now)"),
+		ExpressionAttributeNames: map[string]string{"#state": "State"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":inProgress": &types.AttributeValueMemberS{Value: stateInProgress},
+			":now":        epoch(now),
+		},
+	})
+	if dberr.ConditionFailed(err) {
+		return replay(ctx, store, table, recordKey, requestHash)
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, fmt.Errorf("idempotency: claim key: %w", err)
+	}
+
+	// 2. Run the request
+	res, err := fn()
+	if err != nil || !storable(res.StatusCode) {
+		// Let the client retry with the same key
+		if _, delErr := store.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: aws.String(table), Key: recordKey}); delErr != nil {
+			fmt.Println("Idempotency Error: release key:", delErr)
+		}
+		return res, err
+	}
+
+	// 3. Remember the response
+	headers := make(map[string]types.AttributeValue, len(res.Headers))
+	for k, v := range res.Headers {
+		headers[k] = &types.AttributeValueMemberS{Value: v}
+	}
+	_, updErr := store.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(table),
+		Key:                      recordKey,
+		UpdateExpression:         aws.String("SET #state = :completed, StatusCode = :code, Body = :body, Headers = :headers REMOVE LockedUntil"),
+		ExpressionAttributeNames: map[string]string{"#state": "State"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":completed": &types.AttributeValueMemberS{Value: stateCompleted},
+			":code":      &types.AttributeValueMemberN{Value: strconv.Itoa(res.StatusCode)},
+			":body":      &types.AttributeValueMemberS{Value: res.Body},
+			":headers":   &types.AttributeValueMemberM{Value: headers},
+		},
+	})
+	if updErr != nil {
+		// The write already happened; the lock will simply time out
+		fmt.Println("Idempotency Error: store response:", updErr)
+	}
+	return res, nil
+}
+
+func replay(ctx context.Context, store Store, table string, recordKey map[string]types.AttributeValue, requestHash string) (events.APIGatewayProxyResponse, error) {
+	out, err := store.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            recordKey,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, fmt.Errorf("idempotency: read record: %w", err)
+	}
+	if out.Item == nil {
+		// Expired between our write and read; extremely unlikely, ask for a retry
+		return message(409, "Request with this Idempotency-Key is being processed"), nil
+	}
+
+	if h, ok := out.Item["RequestHash"].(*types.AttributeValueMemberS); ok && h.Value != requestHash {
+		return message(422, "Idempotency-Key was already used with a different request"), nil
+	}
+	if s, ok := out.Item["State"].(*types.AttributeValueMemberS); !ok || s.Value != stateCompleted {
+		return message(409, "Request with this Idempotency-Key is being processed"), nil
+	}
+
+	res := events.APIGatewayProxyResponse{Headers: map[string]string{}}
+	if v, ok := out.Item["StatusCode"].(*types.AttributeValueMemberN); ok {
+		res.StatusCode, _ = strconv.Atoi(v.Value)
+	}
+	if v, ok := out.Item["Body"].(*types.AttributeValueMemberS); ok {
+		res.Body = v.Value
+	}
+	if v, ok := out.Item["Headers"].(*types.AttributeValueMemberM); ok {
+		for k, hv := range v.Value {
+			if s, ok := hv.(*types.AttributeValueMemberS); ok {
+				res.Headers[k] = s.Value
+			}
+		}
+	}
+	res.Headers[ReplayHeader] = "true"
+	return res, nil
+}
+
+// storable decides whether a response is final. Only successes are: a 4xx
+// can depend on state that changes, such as "Not enough points" until the
+// member earns more, a tier or eligibility rejection, or a voucher not
+// published yet. A retry of a 4xx runs the handler again, which answers a
+// malformed body the same way without replaying a stale business failure.
+func storable(status int) bool {
+	return status >= 200 && status < 300
+}
+
+func epoch(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func message(status int, msg string) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(map[string]string{"message": msg})
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+	}
+}
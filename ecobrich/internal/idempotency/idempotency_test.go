@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// memoryStore keeps records by PK. Conditional puts fail whenever the record
+// exists, which is enough to exercise the claim/replay paths.
+type memoryStore struct {
+	items map[string]map[string]types.AttributeValue
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{items: map[string]map[string]types.AttributeValue{}}
+}
+
+func pk(key map[string]types.AttributeValue) string {
+	return key["PK"].(*types.AttributeValueMemberS).Value
+}
+
+func (s *memoryStore) GetItem(ctx context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: s.items[pk(in.Key)]}, nil
+}
+
+func (s *memoryStore) PutItem(ctx context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if _, ok := s.items[pk(in.Item)]; ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	s.items[pk(in.Item)] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (s *memoryStore) UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	item := s.items[pk(in.Key)]
+	item["State"] = in.ExpressionAttributeValues[":completed"]
+	item["StatusCode"] = in.ExpressionAttributeValues[":code"]
+	item["Body"] = in.ExpressionAttributeValues[":body"]
+	item["Headers"] = in.ExpressionAttributeValues[":headers"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (s *memoryStore) DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	delete(s.items, pk(in.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func post(body, key string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/donate",
+		Body:       body,
+		Headers:    map[string]string{"idempotency-key": key},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "u1"}},
+		},
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	status := 200
+	handler := Middleware(newMemoryStore(), "table", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls++
+		return events.APIGatewayProxyResponse{StatusCode: status, Body: `{"message":"ok"}`}, nil
+	})
+	ctx := context.Background()
+
+	testCases := []struct {
+		name           string
+		request        events.APIGatewayProxyRequest
+		handlerStatus  int
+		expectedStatus int
+		expectedCalls  int
+		replayed       bool
+	}{
+		{name: "first request runs", request: post(`{"amount":1}`, "k1"), handlerStatus: 200, expectedStatus: 200, expectedCalls: 1},
+		{name: "retry is replayed", request: post(`{"amount":1}`, "k1"), handlerStatus: 200, expectedStatus: 200, expectedCalls: 1, replayed: true},
+		{name: "different body is rejected", request: post(`{"amount":2}`, "k1"), handlerStatus: 200, expectedStatus: 422, expectedCalls: 1},
+		{name: "new key runs", request: post(`{"amount":1}`, "k2"), handlerStatus: 200, expectedStatus: 200, expectedCalls: 2},
+		{name: "server error is not stored", request: post(`{}`, "k3"), handlerStatus: 500, expectedStatus: 500, expectedCalls: 3},
+		{name: "retry after server error runs again", request: post(`{}`, "k3"), handlerStatus: 200, expectedStatus: 200, expectedCalls: 4},
+		{name: "no key always runs", request: post(`{}`, ""), handlerStatus: 200, expectedStatus: 200, expectedCalls: 5},
+		{name: "no key always runs twice", request: post(`{}`, ""), handlerStatus: 200, expectedStatus: 200, expectedCalls: 6},
+		// e.g. "Not enough points": the retry after earning more must not get it back
+		{name: "balance-dependent rejection is not stored", request: post(`{"voucher_id":"v1"}`, "k4"), handlerStatus: 400, expectedStatus: 400, expectedCalls: 7},
+		{name: "retry after rejection runs again", request: post(`{"voucher_id":"v1"}`, "k4"), handlerStatus: 200, expectedStatus: 200, expectedCalls: 8},
+		{name: "forbidden is not stored", request: post(`{"voucher_id":"v2"}`, "k5"), handlerStatus: 403, expectedStatus: 403, expectedCalls: 9},
+		{name: "retry after forbidden runs again", request: post(`{"voucher_id":"v2"}`, "k5"), handlerStatus: 200, expectedStatus: 200, expectedCalls: 10},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			status = testCase.handlerStatus
+			res, err := handler(ctx, testCase.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != testCase.expectedStatus {
+				t.Errorf("Expected status %d, but got %d", testCase.expectedStatus, res.StatusCode)
+			}
+			if calls != testCase.expectedCalls {
+				t.Errorf("Expected %d handler calls, but got %d", testCase.expectedCalls, calls)
+			}
+			if got := res.Headers[ReplayHeader] == "true"; got != testCase.replayed {
+				t.Errorf("Expected replayed %v, but got %v", testCase.replayed, got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSkipsReads(t *testing.T) {
+	calls := 0
+	handler := Middleware(newMemoryStore(), "table", func(ctx context.Context, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls++
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	})
+	req := post("", "k1")
+	req.HTTPMethod = "GET"
+	for i := 0; i < 2; i++ {
+		if _, err := handler(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected GET to bypass idempotency, but handler ran %d times", calls)
+	}
+}
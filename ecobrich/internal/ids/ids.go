@@ -0,0 +1,146 @@
+// Package ids generates the identifiers used in sort keys (TRANS#, REDEEM#,
+// VOUCHER#, DEF#, ...). IDs are ULIDs: 26 Crockford base32 characters, a
+// 48-bit millisecond timestamp followed by 80 random bits. They sort by
+// creation time and two writes in the same millisecond still get distinct
+// keys, unlike the RFC3339 seconds used previously.
+package ids
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	// Length of an encoded ID.
+	Length = 26
+)
+
+var ErrInvalid = errors.New("ids: invalid id")
+
+var (
+	mu       sync.Mutex
+	lastMs   uint64
+	lastRand [10]byte
+)
+
+// New returns a fresh ID for the current time.
+func New() string {
+	return NewAt(time.Now())
+}
+
+// NewAt returns an ID for t. IDs generated for the same millisecond by this
+// process are strictly increasing.
+func NewAt(t time.Time) string {
+	ms := uint64(t.UnixMilli())
+
+	mu.Lock()
+	var r [10]byte
+	if ms == lastMs {
+		r = lastRand
+		increment(&r)
+	} else {
+		if _, err := rand.Read(r[:]); err != nil {
+			panic("ids: crypto/rand failed: " + err.Error())
+		}
+	}
+	lastMs, lastRand = ms, r
+	mu.Unlock()
+
+	return encode(ms, r)
+}
+
+// Time returns the timestamp embedded in an ID.
+func Time(id string) (time.Time, error) {
+	if len(id) != Length {
+		return time.Time{}, ErrInvalid
+	}
+	var ms uint64
+	for i := 0; i < 10; i++ {
+		v := strings.IndexByte(encoding, upper(id[i]))
+		if v < 0 {
+			return time.Time{}, ErrInvalid
+		}
+		ms = ms<<5 | uint64(v)
+	}
+	for i := 10; i < Length; i++ {
+		if strings.IndexByte(encoding, upper(id[i])) < 0 {
+			return time.Time{}, ErrInvalid
+		}
+	}
+	// The first character only carries 3 bits of a 48-bit timestamp
+	if ms >= 1<<48 {
+		return time.Time{}, ErrInvalid
+	}
+	return time.UnixMilli(int64(ms)).UTC(), nil
+}
+
+// TimeOfSK extracts the creation time from a sort key such as
+// "TRANS#<id>". Besides ULIDs it understands the legacy suffixes still
+// present in older items: RFC3339 timestamps and Unix nanoseconds.
+func TimeOfSK(sk string) (time.Time, bool) {
+	suffix := sk[strings.LastIndexByte(sk, '#')+1:]
+	if t, err := Time(suffix); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC3339, suffix); err == nil {
+		return t, true
+	}
+	if n, err := strconv.ParseInt(suffix, 10, 64); err == nil && n > 0 {
+		return time.Unix(0, n).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// IsLegacySK reports whether sk still uses an RFC3339 suffix and should be
+// rewritten by the ID migration.
+func IsLegacySK(sk string) bool {
+	suffix := sk[strings.LastIndexByte(sk, '#')+1:]
+	_, err := time.Parse(time.RFC3339, suffix)
+	return err == nil
+}
+
+func encode(ms uint64, r [10]byte) string {
+	var out [Length]byte
+	// 10 characters of timestamp, most significant first
+	for i := 9; i >= 0; i-- {
+		out[i] = encoding[ms&31]
+		ms >>= 5
+	}
+	// 16 characters for the 80 random bits
+	var acc uint64
+	bits := 0
+	pos := 10
+	for _, b := range r {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = encoding[(acc>>uint(bits))&31]
+			pos++
+		}
+	}
+	return string(out[:])
+}
+
+// increment adds one to the random part. Overflow after 2^80 IDs in one
+// millisecond is not a practical concern.
+func increment(r *[10]byte) {
+	for i := len(r) - 1; i >= 0; i-- {
+		r[i]++
+		if r[i] != 0 {
+			return
+		}
+	}
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
@@ -0,0 +1,80 @@
+package ids
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNewIsUniqueAndSorted(t *testing.T) {
+	// Same millisecond on purpose: this is where RFC3339 keys collided
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	const n = 1000
+	got := make([]string, n)
+	seen := map[string]bool{}
+	for i := range got {
+		got[i] = NewAt(at)
+		if len(got[i]) != Length {
+			t.Fatalf("Expected length %d, but got %q", Length, got[i])
+		}
+		if seen[got[i]] {
+			t.Fatalf("Duplicate id %q", got[i])
+		}
+		seen[got[i]] = true
+	}
+	if !sort.StringsAreSorted(got) {
+		t.Errorf("Expected ids generated in order to sort in order")
+	}
+
+	later := NewAt(at.Add(time.Millisecond))
+	if later <= got[n-1] {
+		t.Errorf("Expected %q to sort after %q", later, got[n-1])
+	}
+}
+
+func TestTime(t *testing.T) {
+	at := time.Date(2025, 12, 31, 23, 59, 59, 123e6, time.UTC)
+	got, err := Time(NewAt(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(at) {
+		t.Errorf("Expected %v, but got %v", at, got)
+	}
+
+	for _, bad := range []string{"", "short", "01HZZZZZZZZZZZZZZZZZZZZZZU", "8ZZZZZZZZZZZZZZZZZZZZZZZZZ"} {
+		if _, err := Time(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestTimeOfSK(t *testing.T) {
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name   string
+		sk     string
+		legacy bool
+	}{
+		{name: "ulid", sk: "TRANS#" + NewAt(want)},
+		{name: "rfc3339", sk: "TRANS#2024-05-01T10:00:00Z", legacy: true},
+		{name: "rfc3339 with offset", sk: "REDEEM#2024-05-01T17:00:00+07:00", legacy: true},
+		{name: "unix nanos", sk: "VOUCHER#1714557600000000000"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, ok := TimeOfSK(testCase.sk)
+			if !ok || !got.Equal(want) {
+				t.Errorf("Expected %v, but got %v (ok=%v)", want, got, ok)
+			}
+			if IsLegacySK(testCase.sk) != testCase.legacy {
+				t.Errorf("Expected IsLegacySK %v", testCase.legacy)
+			}
+		})
+	}
+
+	if _, ok := TimeOfSK("PROFILE"); ok {
+		t.Errorf("PROFILE has no time")
+	}
+}
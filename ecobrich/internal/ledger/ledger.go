@@ -0,0 +1,252 @@
+// Package ledger reads the points history stored under USER#<sub> and
+// derives a user's balance from it. Every Lambda that needs a balance goes
+// through Summarize so the counting rules live in one place.
+package ledger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/ids"
+)
+
+// EntryType is the "Type" attribute of a history item.
+type EntryType string
+
+const (
+	TypeDonate     EntryType = "DONATE"      // Donation at a collection point, credited once approved
+	TypeAdminAward EntryType = "ADMIN_AWARD" // Points awarded directly by an admin
+	TypeRedeem     EntryType = "REDEEM"      // Points spent on a voucher
+	TypeAdjust     EntryType = "ADJUST"      // Manual correction, PointsEarned may be negative
+	TypeExpire     EntryType = "EXPIRE"      // Points of a lot that reached its expiry, in PointsSpent
+	TypeTierChange EntryType = "TIER_CHANGE" // Promotion or demotion, carries no points
+	TypeReversal   EntryType = "REVERSAL"    // Refund of a REDEEM undone by an admin, in PointsEarned
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Entry is one ledger-changing item from the user's partition.
+type Entry struct {
+	SK              string
+	Type            EntryType
+	Status          string
+	AmountKg        amount.Grams
+	PointsEarned    amount.Points
+	PointsSpent     amount.Points
+	Note            string
+	CreatedAt       string
+	DecidedAt       string       // Approval time of a reviewed donation
+	ExpiresAt       string       // Expiry of the lot a credit opens, see LotExpiry
+	LotSK           string       // Lot closed by an EXPIRE entry
+	Allocations     []Allocation // Lots a REDEEM drew from
+	Material        string       // Plastic type of a DONATE or ADMIN_AWARD
+	CollectionPoint string       // Where a donation was dropped off
+	CampaignID      string       // Campaign whose bonus is included in PointsEarned
+	ReversalOf      string       // REDEEM refunded by a REVERSAL
+}
+
+// Settled reports whether the entry counts towards the balance. Items written
+// before Status was introduced have no status and are treated as approved.
+func (e Entry) Settled() bool {
+	return e.Status == StatusApproved || e.Status == ""
+}
+
+// Delta is the signed effect of a settled entry on the balance.
+func (e Entry) Delta() amount.Points {
+	return e.PointsEarned - e.PointsSpent
+}
+
+// Balance is the aggregate view of a user's ledger.
+type Balance struct {
+	Points        amount.Points `json:"points"`         // Spendable points
+	Earned        amount.Points `json:"earned"`         // Lifetime points credited
+	Spent         amount.Points `json:"spent"`          // Lifetime points debited
+	Expired       amount.Points `json:"expired"`        // Lifetime points lost to expiry
+	Kg            amount.Grams  `json:"kg"`             // Lifetime approved plastic
+	PendingPoints amount.Points `json:"pending_points"` // Points waiting for donation approval
+	Donations     int           `json:"donations"`      // Approved donations
+}
+
+// Summarize folds entries into a Balance.
+func Summarize(entries []Entry) Balance {
+	var b Balance
+	for _, e := range entries {
+		if !e.Settled() {
+			if e.Status == StatusPending {
+				b.PendingPoints += e.PointsEarned
+			}
+			continue
+		}
+		b.Points += e.Delta()
+		if e.Type == TypeReversal {
+			// A refund undoes a spend rather than earning points
+			b.Spent -= e.PointsEarned
+		} else {
+			b.Earned += e.PointsEarned
+		}
+		if e.Type == TypeExpire {
+			b.Expired += e.PointsSpent
+		} else {
+			b.Spent += e.PointsSpent
+		}
+		if e.Type == TypeDonate || e.Type == TypeAdminAward {
+			b.Kg += e.AmountKg
+		}
+		if e.Type == TypeDonate {
+			b.Donations++
+		}
+	}
+	return b
+}
+
+// UserPK is the partition key holding a user's profile and history.
+func UserPK(userID string) string {
+	return "USER#" + userID
+}
+
+// IsEntryType reports whether t is one of the ledger entry types.
+func IsEntryType(t string) bool {
+	switch EntryType(t) {
+	case TypeDonate, TypeAdminAward, TypeRedeem, TypeAdjust, TypeExpire, TypeTierChange, TypeReversal:
+		return true
+	}
+	return false
+}
+
+// FromItem decodes a DynamoDB item. ok is false for items in the user
+// partition that are not ledger entries (PROFILE, USER_VOUCHER, ...).
+func FromItem(item map[string]types.AttributeValue) (e Entry, ok bool, err error) {
+	t := stringAttr(item, "Type")
+	if !IsEntryType(t) {
+		return Entry{}, false, nil
+	}
+	e = Entry{
+		SK:              stringAttr(item, "SK"),
+		Type:            EntryType(t),
+		Status:          stringAttr(item, "Status"),
+		Note:            stringAttr(item, "Note"),
+		CreatedAt:       stringAttr(item, "CreatedAt"),
+		DecidedAt:       stringAttr(item, "DecidedAt"),
+		ExpiresAt:       stringAttr(item, "ExpiresAt"),
+		LotSK:           stringAttr(item, "LotSK"),
+		Material:        stringAttr(item, "Material"),
+		CollectionPoint: stringAttr(item, "CollectionPoint"),
+		CampaignID:      stringAttr(item, "CampaignID"),
+		ReversalOf:      stringAttr(item, "ReversalOf"),
+	}
+	if e.AmountKg, err = amount.GramsAttr(item, "AmountKg"); err != nil {
+		return Entry{}, false, fmt.Errorf("ledger: %s: %w", e.SK, err)
+	}
+	if e.PointsEarned, err = amount.PointsAttr(item, "PointsEarned"); err != nil {
+		return Entry{}, false, fmt.Errorf("ledger: %s: %w", e.SK, err)
+	}
+	if e.PointsSpent, err = amount.PointsAttr(item, "PointsSpent"); err != nil {
+		return Entry{}, false, fmt.Errorf("ledger: %s: %w", e.SK, err)
+	}
+	if e.Allocations, err = allocationsFromItem(item); err != nil {
+		return Entry{}, false, fmt.Errorf("ledger: %s: %w", e.SK, err)
+	}
+	return e, true, nil
+}
+
+// Load reads every ledger entry of a user, following pagination.
+func Load(ctx context.Context, api dynamodb.QueryAPIClient, table, userID string) ([]Entry, error) {
+	p := dynamodb.NewQueryPaginator(api, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: UserPK(userID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+
+	var entries []Entry
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			e, ok, err := FromItem(item)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// LoadBalance is Load followed by Summarize.
+func LoadBalance(ctx context.Context, api dynamodb.QueryAPIClient, table, userID string) (Balance, error) {
+	entries, err := Load(ctx, api, table, userID)
+	if err != nil {
+		return Balance{}, err
+	}
+	return Summarize(entries), nil
+}
+
+// ReadAPI is the subset of the DynamoDB client needed by GetItem.
+type ReadAPI interface {
+	GetItemAPI
+	dynamodb.QueryAPIClient
+}
+
+// GetItem reads one item of the user's partition by sort key. Items whose
+// RFC3339 key was rewritten by the ID migration keep the old key in LegacySK,
+// so references handed out before the migration still resolve.
+func GetItem(ctx context.Context, api ReadAPI, table, userID, sk string) (map[string]types.AttributeValue, error) {
+	out, err := api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: UserPK(userID)},
+			"SK": &types.AttributeValueMemberS{Value: sk},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item != nil || !ids.IsLegacySK(sk) {
+		if err != nil {
+			return nil, err
+		}
+		return out.Item, nil
+	}
+
+	p := dynamodb.NewQueryPaginator(api, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk"),
+		FilterExpression:       aws.String("LegacySK = :sk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: UserPK(userID)},
+			":sk": &types.AttributeValueMemberS{Value: sk},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Items) > 0 {
+			return page.Items[0], nil
+		}
+	}
+	return nil, nil
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
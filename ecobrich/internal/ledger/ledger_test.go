@@ -0,0 +1,187 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+)
+
+func TestSummarize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entries  []Entry
+		expected Balance
+	}{
+		{
+			name:     "empty ledger",
+			expected: Balance{},
+		},
+		{
+			name: "pending donation is not spendable",
+			entries: []Entry{
+				{Type: TypeDonate, Status: StatusPending, AmountKg: 2 * amount.Kg, PointsEarned: 20 * amount.Point},
+			},
+			expected: Balance{PendingPoints: 20 * amount.Point},
+		},
+		{
+			name: "legacy entry without status counts as approved",
+			entries: []Entry{
+				{Type: TypeDonate, Status: "", AmountKg: 1 * amount.Kg, PointsEarned: 10 * amount.Point},
+			},
+			expected: Balance{Points: 10 * amount.Point, Earned: 10 * amount.Point, Kg: 1 * amount.Kg, Donations: 1},
+		},
+		{
+			name: "rejected donation is ignored",
+			entries: []Entry{
+				{Type: TypeDonate, Status: StatusRejected, AmountKg: 5 * amount.Kg, PointsEarned: 50 * amount.Point},
+			},
+			expected: Balance{},
+		},
+		{
+			name: "award, redeem and negative adjustment",
+			entries: []Entry{
+				{Type: TypeAdminAward, Status: StatusApproved, AmountKg: 3 * amount.Kg, PointsEarned: 30 * amount.Point},
+				{Type: TypeRedeem, Status: StatusApproved, PointsSpent: 12 * amount.Point},
+				{Type: TypeAdjust, Status: StatusApproved, PointsEarned: -3 * amount.Point},
+			},
+			expected: Balance{Points: 15 * amount.Point, Earned: 27 * amount.Point, Spent: 12 * amount.Point, Kg: 3 * amount.Kg},
+		},
+		{
+			name: "reversal refunds a redeem without earning",
+			entries: []Entry{
+				{Type: TypeAdminAward, Status: StatusApproved, PointsEarned: 30 * amount.Point},
+				{Type: TypeRedeem, Status: StatusApproved, PointsSpent: 12 * amount.Point},
+				{Type: TypeReversal, Status: StatusApproved, PointsEarned: 12 * amount.Point},
+			},
+			expected: Balance{Points: 30 * amount.Point, Earned: 30 * amount.Point},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := Summarize(testCase.entries)
+			if got != testCase.expected {
+				t.Errorf("Expected %+v, but got %+v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestFromItem(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"SK":           &types.AttributeValueMemberS{Value: "TRANS#2024-05-01T10:00:00Z"},
+		"Type":         &types.AttributeValueMemberS{Value: "DONATE"},
+		"Status":       &types.AttributeValueMemberS{Value: "approved"},
+		"AmountKg":     &types.AttributeValueMemberN{Value: "1.500000"},
+		"PointsEarned": &types.AttributeValueMemberN{Value: "15.000000"},
+	}
+	e, ok, err := FromItem(item)
+	if err != nil || !ok {
+		t.Fatalf("Expected a ledger entry, got ok=%v err=%v", ok, err)
+	}
+	if e.AmountKg != 1500 || e.PointsEarned != 15*amount.Point {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	_, ok, _ = FromItem(map[string]types.AttributeValue{
+		"Type": &types.AttributeValueMemberS{Value: "USER_VOUCHER"},
+	})
+	if ok {
+		t.Errorf("USER_VOUCHER must not be treated as a ledger entry")
+	}
+
+	item["PointsEarned"] = &types.AttributeValueMemberN{Value: "ten"}
+	if _, _, err := FromItem(item); err == nil {
+		t.Errorf("Expected an error for a malformed number")
+	}
+}
+
+type pagedQuery struct {
+	pages []*dynamodb.QueryOutput
+	calls int
+}
+
+func (q *pagedQuery) Query(ctx context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	out := q.pages[q.calls]
+	q.calls++
+	return out, nil
+}
+
+func TestLoadFollowsPagination(t *testing.T) {
+	donate := map[string]types.AttributeValue{
+		"Type":         &types.AttributeValueMemberS{Value: "DONATE"},
+		"Status":       &types.AttributeValueMemberS{Value: "approved"},
+		"PointsEarned": &types.AttributeValueMemberN{Value: "10"},
+	}
+	redeem := map[string]types.AttributeValue{
+		"Type":        &types.AttributeValueMemberS{Value: "REDEEM"},
+		"Status":      &types.AttributeValueMemberS{Value: "approved"},
+		"PointsSpent": &types.AttributeValueMemberN{Value: "4"},
+	}
+	profile := map[string]types.AttributeValue{
+		"SK": &types.AttributeValueMemberS{Value: "PROFILE"},
+	}
+	api := &pagedQuery{pages: []*dynamodb.QueryOutput{
+		{
+			Items:            []map[string]types.AttributeValue{profile, donate},
+			LastEvaluatedKey: map[string]types.AttributeValue{"PK": &types.AttributeValueMemberS{Value: "USER#u1"}},
+		},
+		{Items: []map[string]types.AttributeValue{redeem}},
+	}}
+
+	b, err := LoadBalance(context.Background(), api, "table", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.calls != 2 {
+		t.Errorf("Expected 2 pages to be read, but got %d", api.calls)
+	}
+	if b.Points != 6*amount.Point {
+		t.Errorf("Expected balance 6, but got %v", b.Points)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	testCases := []struct {
+		name    string
+		profile Profile
+		balance Balance
+		drifted bool
+	}{
+		{
+			name:    "in sync",
+			profile: Profile{Exists: true, TotalPoints: 30 * amount.Point, TotalKg: 3 * amount.Kg},
+			balance: Balance{Points: 30 * amount.Point, Kg: 3 * amount.Kg},
+		},
+		{
+			name:    "one gram off",
+			profile: Profile{Exists: true, TotalKg: 1001},
+			balance: Balance{Kg: amount.Kg},
+			drifted: true,
+		},
+		{
+			name:    "redeem never subtracted",
+			profile: Profile{Exists: true, TotalPoints: 50 * amount.Point, TotalKg: 5 * amount.Kg},
+			balance: Balance{Points: 20 * amount.Point, Kg: 5 * amount.Kg},
+			drifted: true,
+		},
+		{
+			name:    "missing profile with history",
+			balance: Balance{PendingPoints: 10 * amount.Point},
+			drifted: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, drifted := Compare(testCase.profile, testCase.balance)
+			if drifted != testCase.drifted {
+				t.Errorf("Expected drifted %v, but got %v", testCase.drifted, drifted)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/ids"
+)
+
+// Points expire LotLifetimeMonths after they are credited. Every settled
+// credit (an approved DONATE or ADMIN_AWARD, or a positive ADJUST) is a lot;
+// spends and expirations draw the lots down, the one expiring first first.
+//
+// Lots are not stored as separate items. They are replayed from the ledger
+// in time order, which keeps the history the single source of truth. REDEEM
+// items record the lots they drew from and EXPIRE items the lot they closed,
+// so a replay reproduces the same allocation even for old entries. A
+// REVERSAL copies the lots of the REDEEM it refunds and gives the points back
+// to those still valid when it is written. Points of a lot that expired in
+// the meantime open a new lot, since the expiry job closes a lot only once.
+const (
+	LotLifetimeMonths = 12
+	// ExpiringWindow is how far ahead the balance reports expiring points.
+	ExpiringWindow = 30 * 24 * time.Hour
+)
+
+// LotExpiry is when points credited at earned expire.
+func LotExpiry(earned time.Time) time.Time {
+	return earned.AddDate(0, LotLifetimeMonths, 0)
+}
+
+// Lot is what is left of one credit.
+type Lot struct {
+	SK        string        `json:"sk"`
+	Points    amount.Points `json:"points"`
+	Remaining amount.Points `json:"remaining"`
+	EarnedAt  time.Time     `json:"earned_at"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+// Expired reports whether the lot can no longer be spent at t.
+func (l Lot) Expired(t time.Time) bool {
+	return !t.Before(l.ExpiresAt)
+}
+
+// Allocation is the part of a debit taken from one lot.
+type Allocation struct {
+	LotSK  string
+	Points amount.Points
+}
+
+// Lots replays entries and returns every lot with its remaining points,
+// ordered by expiry.
+func Lots(entries []Entry) []Lot {
+	type event struct {
+		at time.Time
+		e  Entry
+	}
+	var events []event
+	for _, e := range entries {
+		if !e.Settled() || e.Delta() == 0 {
+			continue
+		}
+		at := entryTime(e)
+		if e.Delta() > 0 {
+			at = earnedAt(e)
+		}
+		events = append(events, event{at: at, e: e})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].at.Equal(events[j].at) {
+			return events[i].at.Before(events[j].at)
+		}
+		return events[i].e.SK < events[j].e.SK
+	})
+
+	var lots []*Lot
+	bySK := map[string]*Lot{}
+	for _, ev := range events {
+		e := ev.e
+		if e.Type == TypeReversal {
+			due := e.Delta()
+			for _, a := range e.Allocations {
+				if l := bySK[a.LotSK]; l != nil && !l.Expired(ev.at) {
+					give := min(a.Points, l.Points-l.Remaining, due)
+					l.Remaining += give
+					due -= give
+				}
+			}
+			if due <= 0 {
+				continue
+			}
+			// Refunds of expired lots, or of redeems written before lots
+			// existed, open a lot of their own
+			e.PointsEarned = due
+		}
+		if e.Delta() > 0 {
+			l := &Lot{SK: e.SK, Points: e.Delta(), Remaining: e.Delta(), EarnedAt: ev.at, ExpiresAt: lotExpiry(e, ev.at)}
+			lots = append(lots, l)
+			bySK[l.SK] = l
+			continue
+		}
+
+		due := -e.Delta()
+		recorded := e.Allocations
+		if e.Type == TypeExpire && e.LotSK != "" {
+			recorded = []Allocation{{LotSK: e.LotSK, Points: due}}
+		}
+		for _, a := range recorded {
+			if l := bySK[a.LotSK]; l != nil {
+				take := min(a.Points, l.Remaining, due)
+				l.Remaining -= take
+				due -= take
+			}
+		}
+		// Entries written before lots existed: take the oldest lots
+		for _, a := range allocate(lots, due, ev.at) {
+			bySK[a.LotSK].Remaining -= a.Points
+		}
+	}
+
+	out := make([]Lot, len(lots))
+	for i, l := range lots {
+		out[i] = *l
+	}
+	sortByExpiry(out)
+	return out
+}
+
+// Spendable is the sum of the lots still valid at t. It can be lower than
+// Balance.Points until the expiry job has written the EXPIRE entries.
+func Spendable(lots []Lot, t time.Time) amount.Points {
+	var p amount.Points
+	for _, l := range lots {
+		if !l.Expired(t) {
+			p += l.Remaining
+		}
+	}
+	return p
+}
+
+// Allocate picks the lots a spend of cost at t draws from, soonest expiry
+// first. It returns false if the valid lots do not cover cost.
+func Allocate(lots []Lot, cost amount.Points, t time.Time) ([]Allocation, bool) {
+	ptrs := make([]*Lot, len(lots))
+	for i := range lots {
+		l := lots[i]
+		ptrs[i] = &l
+	}
+	allocs := allocate(ptrs, cost, t)
+	var got amount.Points
+	for _, a := range allocs {
+		got += a.Points
+	}
+	return allocs, got == cost
+}
+
+func allocate(lots []*Lot, cost amount.Points, t time.Time) []Allocation {
+	order := make([]*Lot, len(lots))
+	copy(order, lots)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].ExpiresAt.Before(order[j].ExpiresAt) })
+
+	var allocs []Allocation
+	for _, l := range order {
+		if cost <= 0 {
+			break
+		}
+		if l.Remaining <= 0 || l.Expired(t) {
+			continue
+		}
+		take := min(l.Remaining, cost)
+		allocs = append(allocs, Allocation{LotSK: l.SK, Points: take})
+		cost -= take
+	}
+	return allocs
+}
+
+// Due returns the lots that have expired at t and still hold points.
+func Due(lots []Lot, t time.Time) []Lot {
+	var due []Lot
+	for _, l := range lots {
+		if l.Expired(t) && l.Remaining > 0 {
+			due = append(due, l)
+		}
+	}
+	return due
+}
+
+// Expiry summarises what is about to expire, for the balance API.
+type Expiry struct {
+	ExpiringPoints amount.Points `json:"expiring_points"` // Valid now, expiring before ExpiringBefore
+	ExpiringBefore string        `json:"expiring_before"`
+	NextExpiresAt  string        `json:"next_expires_at,omitempty"` // Earliest expiry of a lot with points left
+}
+
+// UpcomingExpiry reports the points expiring within ExpiringWindow of t.
+func UpcomingExpiry(lots []Lot, t time.Time) Expiry {
+	until := t.Add(ExpiringWindow)
+	x := Expiry{ExpiringBefore: until.UTC().Format(time.RFC3339)}
+	for _, l := range lots {
+		if l.Remaining <= 0 || l.Expired(t) {
+			continue
+		}
+		if x.NextExpiresAt == "" {
+			x.NextExpiresAt = l.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		if l.ExpiresAt.Before(until) {
+			x.ExpiringPoints += l.Remaining
+		}
+	}
+	return x
+}
+
+// AllocationsAttr encodes allocations for the "Lots" attribute of a REDEEM.
+func AllocationsAttr(allocs []Allocation) types.AttributeValue {
+	list := make([]types.AttributeValue, len(allocs))
+	for i, a := range allocs {
+		list[i] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"SK":     &types.AttributeValueMemberS{Value: a.LotSK},
+			"Points": a.Points.Attr(),
+		}}
+	}
+	return &types.AttributeValueMemberL{Value: list}
+}
+
+func allocationsFromItem(item map[string]types.AttributeValue) ([]Allocation, error) {
+	l, ok := item["Lots"].(*types.AttributeValueMemberL)
+	if !ok {
+		return nil, nil
+	}
+	allocs := make([]Allocation, 0, len(l.Value))
+	for _, v := range l.Value {
+		m, ok := v.(*types.AttributeValueMemberM)
+		if !ok {
+			return nil, fmt.Errorf("Lots: unexpected %T", v)
+		}
+		p, err := amount.PointsAttr(m.Value, "Points")
+		if err != nil {
+			return nil, fmt.Errorf("Lots: %w", err)
+		}
+		allocs = append(allocs, Allocation{LotSK: stringAttr(m.Value, "SK"), Points: p})
+	}
+	return allocs, nil
+}
+
+func sortByExpiry(lots []Lot) {
+	sort.SliceStable(lots, func(i, j int) bool { return lots[i].ExpiresAt.Before(lots[j].ExpiresAt) })
+}
+
+// earnedAt is when a credit started to count: the approval for donations
+// reviewed by an admin, otherwise the entry's creation.
+func earnedAt(e Entry) time.Time {
+	if t, err := time.Parse(time.RFC3339, e.DecidedAt); err == nil {
+		return t
+	}
+	return entryTime(e)
+}
+
+func lotExpiry(e Entry, earned time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, e.ExpiresAt); err == nil {
+		return t
+	}
+	return LotExpiry(earned)
+}
+
+func entryTime(e Entry) time.Time {
+	if t, err := time.Parse(time.RFC3339, e.CreatedAt); err == nil {
+		return t
+	}
+	t, _ := ids.TimeOfSK(e.SK)
+	return t
+}
@@ -0,0 +1,178 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"hello-world/internal/amount"
+)
+
+func remaining(lots []Lot) map[string]amount.Points {
+	m := map[string]amount.Points{}
+	for _, l := range lots {
+		m[l.SK] = l.Remaining
+	}
+	return m
+}
+
+func TestLots(t *testing.T) {
+	jan := Entry{SK: "TRANS#jan", Type: TypeAdminAward, Status: StatusApproved, PointsEarned: 100 * amount.Point, CreatedAt: "2024-01-10T00:00:00Z"}
+	// Donated in February but only approved in March: the clock starts at approval
+	mar := Entry{SK: "TRANS#feb", Type: TypeDonate, Status: StatusApproved, PointsEarned: 50 * amount.Point, CreatedAt: "2024-02-01T00:00:00Z", DecidedAt: "2024-03-01T00:00:00Z"}
+	pending := Entry{SK: "TRANS#pending", Type: TypeDonate, Status: StatusPending, PointsEarned: 70 * amount.Point, CreatedAt: "2024-03-05T00:00:00Z"}
+
+	testCases := []struct {
+		name     string
+		entries  []Entry
+		expected map[string]amount.Points
+	}{
+		{
+			name:     "credits become lots, pending donations do not",
+			entries:  []Entry{mar, jan, pending},
+			expected: map[string]amount.Points{"TRANS#jan": 100 * amount.Point, "TRANS#feb": 50 * amount.Point},
+		},
+		{
+			name: "legacy redeem takes the oldest lot first",
+			entries: []Entry{jan, mar,
+				{SK: "REDEEM#1", Type: TypeRedeem, Status: StatusApproved, PointsSpent: 120 * amount.Point, CreatedAt: "2024-04-01T00:00:00Z"},
+			},
+			expected: map[string]amount.Points{"TRANS#jan": 0, "TRANS#feb": 30 * amount.Point},
+		},
+		{
+			name: "recorded allocation is replayed as written",
+			entries: []Entry{jan, mar,
+				{SK: "REDEEM#1", Type: TypeRedeem, Status: StatusApproved, PointsSpent: 20 * amount.Point, CreatedAt: "2024-04-01T00:00:00Z",
+					Allocations: []Allocation{{LotSK: "TRANS#feb", Points: 20 * amount.Point}}},
+			},
+			expected: map[string]amount.Points{"TRANS#jan": 100 * amount.Point, "TRANS#feb": 30 * amount.Point},
+		},
+		{
+			name: "expire entry closes its lot",
+			entries: []Entry{jan, mar,
+				{SK: "EXPIRE#jan", Type: TypeExpire, Status: StatusApproved, PointsSpent: 100 * amount.Point, LotSK: "TRANS#jan", CreatedAt: "2025-01-11T00:00:00Z"},
+			},
+			expected: map[string]amount.Points{"TRANS#jan": 0, "TRANS#feb": 50 * amount.Point},
+		},
+		{
+			name: "redeem after expiry skips the expired lot",
+			entries: []Entry{jan, mar,
+				{SK: "REDEEM#1", Type: TypeRedeem, Status: StatusApproved, PointsSpent: 10 * amount.Point, CreatedAt: "2025-01-20T00:00:00Z"},
+			},
+			expected: map[string]amount.Points{"TRANS#jan": 100 * amount.Point, "TRANS#feb": 40 * amount.Point},
+		},
+		{
+			name: "reversal gives the points back to the redeemed lots",
+			entries: []Entry{jan, mar,
+				{SK: "REDEEM#1", Type: TypeRedeem, Status: StatusApproved, PointsSpent: 120 * amount.Point, CreatedAt: "2024-04-01T00:00:00Z",
+					Allocations: []Allocation{{LotSK: "TRANS#jan", Points: 100 * amount.Point}, {LotSK: "TRANS#feb", Points: 20 * amount.Point}}},
+				{SK: "REVERSAL#1", Type: TypeReversal, Status: StatusApproved, PointsEarned: 120 * amount.Point, CreatedAt: "2024-04-02T00:00:00Z",
+					Allocations: []Allocation{{LotSK: "TRANS#jan", Points: 100 * amount.Point}, {LotSK: "TRANS#feb", Points: 20 * amount.Point}}},
+			},
+			expected: map[string]amount.Points{"TRANS#jan": 100 * amount.Point, "TRANS#feb": 50 * amount.Point},
+		},
+		{
+			name: "reversal after expiry refunds the expired lot as a new one",
+			entries: []Entry{jan, mar,
+				{SK: "REDEEM#1", Type: TypeRedeem, Status: StatusApproved, PointsSpent: 120 * amount.Point, CreatedAt: "2024-04-01T00:00:00Z",
+					Allocations: []Allocation{{LotSK: "TRANS#jan", Points: 100 * amount.Point}, {LotSK: "TRANS#feb", Points: 20 * amount.Point}}},
+				{SK: "REVERSAL#1", Type: TypeReversal, Status: StatusApproved, PointsEarned: 120 * amount.Point, CreatedAt: "2025-02-01T00:00:00Z",
+					Allocations: []Allocation{{LotSK: "TRANS#jan", Points: 100 * amount.Point}, {LotSK: "TRANS#feb", Points: 20 * amount.Point}}},
+			},
+			expected: map[string]amount.Points{"TRANS#jan": 0, "TRANS#feb": 50 * amount.Point, "REVERSAL#1": 100 * amount.Point},
+		},
+		{
+			name: "reversal of a legacy redeem opens a lot",
+			entries: []Entry{jan,
+				{SK: "REDEEM#1", Type: TypeRedeem, Status: StatusApproved, PointsSpent: 40 * amount.Point, CreatedAt: "2024-04-01T00:00:00Z"},
+				{SK: "REVERSAL#1", Type: TypeReversal, Status: StatusApproved, PointsEarned: 40 * amount.Point, CreatedAt: "2024-04-02T00:00:00Z"},
+			},
+			expected: map[string]amount.Points{"TRANS#jan": 60 * amount.Point, "REVERSAL#1": 40 * amount.Point},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := remaining(Lots(testCase.entries))
+			if len(got) != len(testCase.expected) {
+				t.Fatalf("Expected lots %v, but got %v", testCase.expected, got)
+			}
+			for sk, want := range testCase.expected {
+				if got[sk] != want {
+					t.Errorf("Expected %s to have %v left, but got %v", sk, want, got[sk])
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateAndExpiry(t *testing.T) {
+	lots := Lots([]Entry{
+		{SK: "TRANS#a", Type: TypeAdminAward, Status: StatusApproved, PointsEarned: 30 * amount.Point, CreatedAt: "2024-01-10T00:00:00Z"},
+		{SK: "TRANS#b", Type: TypeAdminAward, Status: StatusApproved, PointsEarned: 50 * amount.Point, CreatedAt: "2024-06-01T00:00:00Z"},
+	})
+
+	// One week before the first lot expires
+	now := time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)
+	if got := Spendable(lots, now); got != 80*amount.Point {
+		t.Errorf("Expected 80 spendable points, but got %v", got)
+	}
+	allocs, ok := Allocate(lots, 40*amount.Point, now)
+	if !ok || len(allocs) != 2 || allocs[0].LotSK != "TRANS#a" || allocs[0].Points != 30*amount.Point || allocs[1].Points != 10*amount.Point {
+		t.Errorf("Unexpected allocation %+v (ok=%v)", allocs, ok)
+	}
+	x := UpcomingExpiry(lots, now)
+	if x.ExpiringPoints != 30*amount.Point || x.NextExpiresAt != "2025-01-10T00:00:00Z" {
+		t.Errorf("Unexpected expiry %+v", x)
+	}
+
+	// After the first lot expired it can no longer be spent
+	later := time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC)
+	if _, ok := Allocate(lots, 60*amount.Point, later); ok {
+		t.Errorf("Expected expired points not to be spendable")
+	}
+	due := Due(lots, later)
+	if len(due) != 1 || due[0].SK != "TRANS#a" {
+		t.Errorf("Expected TRANS#a to be due, but got %+v", due)
+	}
+
+	if b := Summarize([]Entry{
+		{Type: TypeAdminAward, Status: StatusApproved, PointsEarned: 30 * amount.Point},
+		{Type: TypeExpire, Status: StatusApproved, PointsSpent: 30 * amount.Point},
+	}); b.Points != 0 || b.Expired != 30*amount.Point || b.Spent != 0 {
+		t.Errorf("Unexpected balance %+v", b)
+	}
+}
+
+func TestExpireReverseExpire(t *testing.T) {
+	entries := []Entry{
+		{SK: "TRANS#jan", Type: TypeAdminAward, Status: StatusApproved, PointsEarned: 100 * amount.Point, CreatedAt: "2024-01-10T00:00:00Z"},
+		{SK: "REDEEM#1", Type: TypeRedeem, Status: StatusApproved, PointsSpent: 40 * amount.Point, CreatedAt: "2024-04-01T00:00:00Z",
+			Allocations: []Allocation{{LotSK: "TRANS#jan", Points: 40 * amount.Point}}},
+		{SK: "EXPIRE#jan", Type: TypeExpire, Status: StatusApproved, PointsSpent: 60 * amount.Point, LotSK: "TRANS#jan", CreatedAt: "2025-01-11T00:00:00Z"},
+		{SK: "REVERSAL#1", Type: TypeReversal, Status: StatusApproved, PointsEarned: 40 * amount.Point, CreatedAt: "2025-02-01T00:00:00Z",
+			ExpiresAt: "2026-02-01T00:00:00Z", Allocations: []Allocation{{LotSK: "TRANS#jan", Points: 40 * amount.Point}}},
+	}
+
+	// The refund is spendable and the closed lot is not due a second time
+	soon := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	if due := Due(Lots(entries), soon); len(due) != 0 {
+		t.Errorf("Expected nothing due after the reversal, but got %+v", due)
+	}
+	if got := Spendable(Lots(entries), soon); got != 40*amount.Point {
+		t.Errorf("Expected 40 spendable points, but got %v", got)
+	}
+
+	// A year on, the refund expires as a lot of its own
+	later := time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC)
+	due := Due(Lots(entries), later)
+	if len(due) != 1 || due[0].SK != "REVERSAL#1" || due[0].Remaining != 40*amount.Point {
+		t.Fatalf("Expected REVERSAL#1 to be due with 40 points, but got %+v", due)
+	}
+	entries = append(entries, Entry{SK: "EXPIRE#1", Type: TypeExpire, Status: StatusApproved, PointsSpent: 40 * amount.Point, LotSK: "REVERSAL#1", CreatedAt: "2026-02-02T00:00:00Z"})
+	if due := Due(Lots(entries), later); len(due) != 0 {
+		t.Errorf("Expected nothing due after the second expiry, but got %+v", due)
+	}
+	if b := Summarize(entries); b.Points != 0 || b.Expired != 100*amount.Point {
+		t.Errorf("Unexpected balance %+v", b)
+	}
+}
@@ -0,0 +1,161 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+)
+
+// ProfileSK is the sort key of the per-user profile item.
+const ProfileSK = "PROFILE"
+
+// GetItemAPI is the subset of the DynamoDB client needed to read a profile.
+type GetItemAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// Profile is the stored balance on the PROFILE item. It is a cache of the
+// ledger kept in sync by ProfileUpdate; the history items stay the source of
+// truth and Reconcile compares the two.
+type Profile struct {
+	Exists         bool          `json:"exists"`
+	TotalPoints    amount.Points `json:"total_points"`
+	TotalKg        amount.Grams  `json:"total_kg"`
+	PendingPoints  amount.Points `json:"pending_points"`
+	Tier           string        `json:"tier,omitempty"` // See package tiers; empty until first evaluated
+	BalanceVersion int64         `json:"balance_version"`
+	Segments       []string      `json:"segments,omitempty"` // Groups such as a partner school, see package eligibility
+}
+
+// Delta is the change a ledger write makes to PROFILE.
+type Delta struct {
+	Points        amount.Points
+	Kg            amount.Grams
+	PendingPoints amount.Points
+	Tier          string // Stored as is when set
+}
+
+// ProfileKey is the primary key of a user's PROFILE item.
+func ProfileKey(userID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: UserPK(userID)},
+		"SK": &types.AttributeValueMemberS{Value: ProfileSK},
+	}
+}
+
+// LoadProfile reads PROFILE with a strongly consistent read.
+func LoadProfile(ctx context.Context, api GetItemAPI, table, userID string) (Profile, error) {
+	out, err := api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            ProfileKey(userID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Profile{}, err
+	}
+	if out.Item == nil {
+		return Profile{}, nil
+	}
+
+	p := Profile{Exists: true, Tier: stringAttr(out.Item, "Tier")}
+	if p.TotalPoints, err = amount.PointsAttr(out.Item, "TotalPoints"); err != nil {
+		return Profile{}, fmt.Errorf("ledger: profile: %w", err)
+	}
+	if p.TotalKg, err = amount.GramsAttr(out.Item, "TotalKg"); err != nil {
+		return Profile{}, fmt.Errorf("ledger: profile: %w", err)
+	}
+	if p.PendingPoints, err = amount.PointsAttr(out.Item, "PendingPoints"); err != nil {
+		return Profile{}, fmt.Errorf("ledger: profile: %w", err)
+	}
+	if v, ok := out.Item["Segments"].(*types.AttributeValueMemberSS); ok {
+		p.Segments = v.Value
+	}
+	if v, ok := out.Item["BalanceVersion"].(*types.AttributeValueMemberN); ok {
+		if p.BalanceVersion, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+			return Profile{}, fmt.Errorf("ledger: BalanceVersion %q: %w", v.Value, err)
+		}
+	}
+	return p, nil
+}
+
+// BalanceVersion reads the optimistic-locking counter stored on PROFILE.
+// Users without a profile, or whose profile predates the counter, are at 0.
+func BalanceVersion(ctx context.Context, api GetItemAPI, table, userID string) (int64, error) {
+	p, err := LoadProfile(ctx, api, table, userID)
+	return p.BalanceVersion, err
+}
+
+// ProfileUpdate is the transaction item every ledger write must include so
+// PROFILE moves together with the history. It also bumps BalanceVersion.
+//
+// When expected is non-nil the update fails unless BalanceVersion still
+// equals *expected. A spend is only safe when it is committed with the
+// version its balance was read at: a concurrent write changes the version
+// and cancels the transaction.
+func ProfileUpdate(table, userID string, d Delta, now string, expected *int64) types.TransactWriteItem {
+	update := &types.Update{
+		TableName:        aws.String(table),
+		Key:              ProfileKey(userID),
+		UpdateExpression: aws.String("ADD TotalPoints :p, TotalKg :k, PendingPoints :pp, BalanceVersion :one SET UpdatedAt = :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p":   d.Points.Attr(),
+			":k":   d.Kg.Attr(),
+			":pp":  d.PendingPoints.Attr(),
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":t":   &types.AttributeValueMemberS{Value: now},
+		},
+	}
+	if d.Tier != "" {
+		update.UpdateExpression = aws.String(*update.UpdateExpression + ", Tier = :tier")
+		update.ExpressionAttributeValues[":tier"] = &types.AttributeValueMemberS{Value: d.Tier}
+	}
+	if expected != nil {
+		update.ConditionExpression = aws.String("attribute_not_exists(BalanceVersion) OR BalanceVersion = :v")
+		update.ExpressionAttributeValues[":v"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*expected, 10)}
+	}
+	return types.TransactWriteItem{Update: update}
+}
+
+// ProfileReset overwrites the stored balance with b, provided nothing has
+// written to the ledger since the profile was read at version.
+func ProfileReset(table, userID string, b Balance, version int64, now string) *dynamodb.UpdateItemInput {
+	return &dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 ProfileKey(userID),
+		UpdateExpression:    aws.String("SET TotalPoints = :p, TotalKg = :k, PendingPoints = :pp, BalanceVersion = :next, UpdatedAt = :t"),
+		ConditionExpression: aws.String("attribute_not_exists(BalanceVersion) OR BalanceVersion = :v"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":p":    b.Points.Attr(),
+			":k":    b.Kg.Attr(),
+			":pp":   b.PendingPoints.Attr(),
+			":v":    &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+			":next": &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)},
+			":t":    &types.AttributeValueMemberS{Value: now},
+		},
+	}
+}
+
+// Drift is the difference between PROFILE and the ledger it caches.
+type Drift struct {
+	Points        amount.Points `json:"points"`
+	Kg            amount.Grams  `json:"kg"`
+	PendingPoints amount.Points `json:"pending_points"`
+}
+
+// Compare returns how far p is from b and whether there is any gap. Amounts
+// are exact, so any difference is real; float noise from older writes is
+// already rounded away when PROFILE is decoded.
+func Compare(p Profile, b Balance) (Drift, bool) {
+	d := Drift{
+		Points:        p.TotalPoints - b.Points,
+		Kg:            p.TotalKg - b.Kg,
+		PendingPoints: p.PendingPoints - b.PendingPoints,
+	}
+	return d, d != Drift{}
+}
@@ -0,0 +1,189 @@
+// Package limits caps how often a voucher can be redeemed: per user over
+// its lifetime, per user per day or month, and across all users per day.
+// Each limit is a counter item incremented in the redemption transaction
+// under a condition that it stays within the limit, so concurrent
+// redemptions cannot overshoot. Counters live in their own partition,
+// PK=LIMIT#<voucher SK>, and period counters expire through the table TTL
+// once their period is over.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Limits is stored on a voucher definition as the Limits map. Zero is
+// unlimited.
+type Limits struct {
+	PerUser        int64 `json:"per_user,omitempty"` // Lifetime
+	PerUserDaily   int64 `json:"per_user_daily,omitempty"`
+	PerUserMonthly int64 `json:"per_user_monthly,omitempty"`
+	GlobalDaily    int64 `json:"global_daily,omitempty"`
+}
+
+// Scope names a limit in API errors, as its JSON field.
+type Scope string
+
+const (
+	PerUser        Scope = "per_user"
+	PerUserDaily   Scope = "per_user_daily"
+	PerUserMonthly Scope = "per_user_monthly"
+	GlobalDaily    Scope = "global_daily"
+)
+
+// Location is where days and months start: members and partners are in
+// Vietnam, which has no daylight saving time.
+var Location = time.FixedZone("ICT", 7*60*60)
+
+// ttlGrace keeps finished period counters around for support questions.
+const ttlGrace = 7 * 24 * time.Hour
+
+// Validate checks limits submitted by an admin.
+func (l Limits) Validate() error {
+	if l.PerUser < 0 || l.PerUserDaily < 0 || l.PerUserMonthly < 0 || l.GlobalDaily < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// IsZero reports whether no limit is set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Counter is one limit applied to one redemption.
+type Counter struct {
+	Scope    Scope
+	Max      int64
+	PK, SK   string
+	ResetsAt time.Time // Zero for the lifetime limit
+}
+
+// Counters returns the counters a redemption of voucherRef by userID at now
+// must increment, in a stable order.
+func (l Limits) Counters(voucherRef, userID string, now time.Time) []Counter {
+	pk := "LIMIT#" + voucherRef
+	local := now.In(Location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, Location)
+	month := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, Location)
+
+	var counters []Counter
+	if l.PerUser > 0 {
+		counters = append(counters, Counter{Scope: PerUser, Max: l.PerUser, PK: pk, SK: "USER#" + userID})
+	}
+	if l.PerUserDaily > 0 {
+		counters = append(counters, Counter{Scope: PerUserDaily, Max: l.PerUserDaily, PK: pk,
+			SK: "USER#" + userID + "#D#" + day.Format("2006-01-02"), ResetsAt: day.AddDate(0, 0, 1)})
+	}
+	if l.PerUserMonthly > 0 {
+		counters = append(counters, Counter{Scope: PerUserMonthly, Max: l.PerUserMonthly, PK: pk,
+			SK: "USER#" + userID + "#M#" + month.Format("2006-01"), ResetsAt: month.AddDate(0, 1, 0)})
+	}
+	if l.GlobalDaily > 0 {
+		counters = append(counters, Counter{Scope: GlobalDaily, Max: l.GlobalDaily, PK: pk,
+			SK: "D#" + day.Format("2006-01-02"), ResetsAt: day.AddDate(0, 0, 1)})
+	}
+	return counters
+}
+
+// Increment counts one redemption. It fails its condition once the counter
+// has reached Max.
+func (c Counter) Increment(table string) types.TransactWriteItem {
+	update := "ADD Redeemed :one"
+	values := map[string]types.AttributeValue{
+		":one": &types.AttributeValueMemberN{Value: "1"},
+		":max": &types.AttributeValueMemberN{Value: strconv.FormatInt(c.Max, 10)},
+	}
+	if !c.ResetsAt.IsZero() {
+		update += " SET ExpiresAtEpoch = :ttl"
+		values[":ttl"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(c.ResetsAt.Add(ttlGrace).Unix(), 10)}
+	}
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: c.PK},
+			"SK": &types.AttributeValueMemberS{Value: c.SK},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_not_exists(Redeemed) OR Redeemed < :max"),
+		ExpressionAttributeValues: values,
+	}}
+}
+
+// Decrement gives back one redemption when it is reversed. It fails its
+// condition if the counter is gone, e.g. after its period ended.
+func (c Counter) Decrement(table string) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: c.PK},
+			"SK": &types.AttributeValueMemberS{Value: c.SK},
+		},
+		UpdateExpression:    aws.String("ADD Redeemed :minus"),
+		ConditionExpression: aws.String("Redeemed > :zero"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":minus": &types.AttributeValueMemberN{Value: "-1"},
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+		},
+	}}
+}
+
+// Message explains a reached limit to the member.
+func (c Counter) Message() string {
+	switch c.Scope {
+	case PerUser:
+		return fmt.Sprintf("You can redeem this voucher at most %d times", c.Max)
+	case PerUserDaily:
+		return fmt.Sprintf("You can redeem this voucher at most %d times a day", c.Max)
+	case PerUserMonthly:
+		return fmt.Sprintf("You can redeem this voucher at most %d times a month", c.Max)
+	case GlobalDaily:
+		return "Today's allocation of this voucher has run out"
+	}
+	return "Redemption limit reached"
+}
+
+// Attr encodes limits as a DynamoDB map.
+func (l Limits) Attr() types.AttributeValue {
+	m := map[string]types.AttributeValue{}
+	for name, v := range map[string]int64{
+		"PerUser":        l.PerUser,
+		"PerUserDaily":   l.PerUserDaily,
+		"PerUserMonthly": l.PerUserMonthly,
+		"GlobalDaily":    l.GlobalDaily,
+	} {
+		if v != 0 {
+			m[name] = &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+		}
+	}
+	return &types.AttributeValueMemberM{Value: m}
+}
+
+// FromItem reads item[name]; an item without it has no limits.
+func FromItem(item map[string]types.AttributeValue, name string) (Limits, error) {
+	var l Limits
+	m, ok := item[name].(*types.AttributeValueMemberM)
+	if !ok {
+		return l, nil
+	}
+	for attr, field := range map[string]*int64{
+		"PerUser":        &l.PerUser,
+		"PerUserDaily":   &l.PerUserDaily,
+		"PerUserMonthly": &l.PerUserMonthly,
+		"GlobalDaily":    &l.GlobalDaily,
+	} {
+		if v, ok := m.Value[attr].(*types.AttributeValueMemberN); ok {
+			n, err := strconv.ParseInt(v.Value, 10, 64)
+			if err != nil {
+				return Limits{}, fmt.Errorf("limits: invalid %s %q", attr, v.Value)
+			}
+			*field = n
+		}
+	}
+	return l, nil
+}
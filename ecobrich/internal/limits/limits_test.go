@@ -0,0 +1,90 @@
+package limits
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestCounters(t *testing.T) {
+	// 18:30 UTC on 31 Jan is already 1 Feb in Vietnam
+	now := time.Date(2026, 1, 31, 18, 30, 0, 0, time.UTC)
+	l := Limits{PerUser: 5, PerUserDaily: 1, PerUserMonthly: 3, GlobalDaily: 100}
+
+	got := l.Counters("DEF#01", "user-1", now)
+	expected := []struct {
+		scope    Scope
+		sk       string
+		resetsAt string
+	}{
+		{PerUser, "USER#user-1", ""},
+		{PerUserDaily, "USER#user-1#D#2026-02-01", "2026-02-01T17:00:00Z"},
+		{PerUserMonthly, "USER#user-1#M#2026-02", "2026-02-28T17:00:00Z"},
+		{GlobalDaily, "D#2026-02-01", "2026-02-01T17:00:00Z"},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d counters, but got %d", len(expected), len(got))
+	}
+	for i, e := range expected {
+		c := got[i]
+		resetsAt := ""
+		if !c.ResetsAt.IsZero() {
+			resetsAt = c.ResetsAt.UTC().Format(time.RFC3339)
+		}
+		if c.Scope != e.scope || c.PK != "LIMIT#DEF#01" || c.SK != e.sk || resetsAt != e.resetsAt {
+			t.Errorf("Counter %d: expected %s %s %q, but got %s %s %s %q", i, e.scope, e.sk, e.resetsAt, c.Scope, c.PK, c.SK, resetsAt)
+		}
+	}
+
+	if n := len(Limits{}.Counters("DEF#01", "user-1", now)); n != 0 {
+		t.Errorf("Expected no counters without limits, but got %d", n)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	counters := Limits{PerUser: 2, PerUserDaily: 1}.Counters("DEF#01", "user-1", now)
+
+	lifetime := counters[0].Increment("table").Update
+	if _, ok := lifetime.ExpressionAttributeValues[":ttl"]; ok {
+		t.Error("Expected the lifetime counter to never expire")
+	}
+	daily := counters[1].Increment("table").Update
+	ttl, ok := daily.ExpressionAttributeValues[":ttl"].(*types.AttributeValueMemberN)
+	if !ok {
+		t.Fatal("Expected the daily counter to expire")
+	}
+	if expected := "1773766800"; ttl.Value != expected { // a week after midnight on 11 March, Vietnam time
+		t.Errorf("Expected ttl %s, but got %s", expected, ttl.Value)
+	}
+}
+
+func TestDecrement(t *testing.T) {
+	c := Limits{PerUser: 2}.Counters("DEF#01", "user-1", time.Now())[0]
+	update := c.Decrement("table").Update
+	if *update.UpdateExpression != "ADD Redeemed :minus" || *update.ConditionExpression != "Redeemed > :zero" {
+		t.Errorf("Expected a guarded decrement, but got %q if %q", *update.UpdateExpression, *update.ConditionExpression)
+	}
+	if key := update.Key["SK"].(*types.AttributeValueMemberS).Value; key != "USER#user-1" {
+		t.Errorf("Expected the lifetime counter, but got %s", key)
+	}
+}
+
+func TestAttrRoundTrip(t *testing.T) {
+	l := Limits{PerUser: 5, GlobalDaily: 100}
+	got, err := FromItem(map[string]types.AttributeValue{"Limits": l.Attr()}, "Limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, l) {
+		t.Errorf("Expected %+v, but got %+v", l, got)
+	}
+	if got, _ := FromItem(map[string]types.AttributeValue{}, "Limits"); !got.IsZero() {
+		t.Errorf("Expected no limits, but got %+v", got)
+	}
+	if err := (Limits{PerUserDaily: -1}).Validate(); err == nil {
+		t.Error("Expected negative limits to be rejected")
+	}
+}
@@ -0,0 +1,239 @@
+// Package rules holds the earning rules that turn kilograms of plastic into
+// points. Rules are stored under PK=CONFIG so admins can change rates per
+// material without a deploy; a rule applies from its EffectiveFrom until a
+// newer rule for the same material takes over.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+)
+
+// Material is the plastic type collected.
+type Material string
+
+const (
+	MaterialHDPE  Material = "HDPE"
+	MaterialPET   Material = "PET"
+	MaterialPP    Material = "PP"
+	MaterialMixed Material = "MIXED"
+)
+
+// Materials lists every accepted material.
+var Materials = []Material{MaterialHDPE, MaterialPET, MaterialPP, MaterialMixed}
+
+// Rounding decides what happens to fractional points.
+type Rounding string
+
+const (
+	RoundNone  Rounding = "none"
+	RoundFloor Rounding = "floor"
+	RoundHalf  Rounding = "round"
+	RoundCeil  Rounding = "ceil"
+)
+
+const (
+	configPK   = "CONFIG"
+	rulePrefix = "RULE#"
+)
+
+var (
+	ErrUnknownMaterial = errors.New("unknown material")
+	ErrBelowMinimum    = errors.New("amount is below the minimum for this material")
+)
+
+// Rule is one earning rate, stored as PK=CONFIG, SK=RULE#<material>#<from>.
+type Rule struct {
+	Material      Material      `json:"material"`
+	PointsPerKg   amount.Points `json:"points_per_kg"`
+	MinKg         amount.Grams  `json:"min_kg"`
+	Rounding      Rounding      `json:"rounding"`
+	EffectiveFrom string        `json:"effective_from"` // RFC3339
+	CreatedBy     string        `json:"created_by,omitempty"`
+}
+
+// DefaultRule is the flat 1 kg = 10 points rate used before any rule was
+// configured, so existing deployments keep their behaviour.
+func DefaultRule(m Material) Rule {
+	return Rule{
+		Material:      m,
+		PointsPerKg:   10 * amount.Point,
+		Rounding:      RoundNone,
+		EffectiveFrom: time.Time{}.Format(time.RFC3339),
+	}
+}
+
+// ParseMaterial accepts any case and defaults an empty value to MIXED.
+func ParseMaterial(s string) (Material, error) {
+	if s == "" {
+		return MaterialMixed, nil
+	}
+	m := Material(strings.ToUpper(strings.TrimSpace(s)))
+	for _, known := range Materials {
+		if m == known {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownMaterial, s)
+}
+
+// ID is the sort key of the rule, also stored on ledger entries as RuleID.
+func (r Rule) ID() string {
+	return rulePrefix + string(r.Material) + "#" + r.EffectiveFrom
+}
+
+// Validate checks a rule submitted by an admin.
+func (r Rule) Validate() error {
+	if _, err := ParseMaterial(string(r.Material)); err != nil {
+		return err
+	}
+	if r.PointsPerKg < 0 {
+		return errors.New("points_per_kg must not be negative")
+	}
+	if r.MinKg < 0 {
+		return errors.New("min_kg must not be negative")
+	}
+	switch r.Rounding {
+	case RoundNone, RoundFloor, RoundHalf, RoundCeil:
+	default:
+		return fmt.Errorf("rounding must be one of none, floor, round, ceil")
+	}
+	if _, err := time.Parse(time.RFC3339, r.EffectiveFrom); err != nil {
+		return errors.New("effective_from must be an RFC3339 timestamp")
+	}
+	return nil
+}
+
+// Points applies the rule to a weight. With RoundNone the result keeps
+// hundredths of a point; the other modes round to whole points.
+func (r Rule) Points(kg amount.Grams) (amount.Points, error) {
+	if kg < r.MinKg {
+		return 0, fmt.Errorf("%w (%s kg)", ErrBelowMinimum, r.MinKg)
+	}
+	p, err := r.PointsPerKg.PerKg(kg)
+	if err != nil {
+		return 0, err
+	}
+	switch r.Rounding {
+	case RoundFloor:
+		p = p.Round(amount.Floor)
+	case RoundHalf:
+		p = p.Round(amount.HalfUp)
+	case RoundCeil:
+		p = p.Round(amount.Ceil)
+	}
+	return p, nil
+}
+
+// Active picks the rule in force for m at the given time: the one with the
+// latest EffectiveFrom not after at. It falls back to DefaultRule.
+func Active(all []Rule, m Material, at time.Time) Rule {
+	best := DefaultRule(m)
+	var bestFrom time.Time
+	found := false
+	for _, r := range all {
+		if r.Material != m {
+			continue
+		}
+		from, err := time.Parse(time.RFC3339, r.EffectiveFrom)
+		if err != nil || from.After(at) {
+			continue
+		}
+		if !found || from.After(bestFrom) {
+			best, bestFrom, found = r, from, true
+		}
+	}
+	return best
+}
+
+// Load reads every stored rule, oldest first per material.
+func Load(ctx context.Context, api dynamodb.QueryAPIClient, table string) ([]Rule, error) {
+	p := dynamodb.NewQueryPaginator(api, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :r)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: configPK},
+			":r":  &types.AttributeValueMemberS{Value: rulePrefix},
+		},
+	})
+
+	var all []Rule
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			r, err := fromItem(item)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, r)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].ID() < all[j].ID() })
+	return all, nil
+}
+
+// Resolve loads the rules and returns the one in force for m at the given time.
+func Resolve(ctx context.Context, api dynamodb.QueryAPIClient, table string, m Material, at time.Time) (Rule, error) {
+	all, err := Load(ctx, api, table)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Active(all, m, at), nil
+}
+
+// PutInput stores a new rule. Rules are immutable: a correction is a new
+// rule with a later EffectiveFrom, which keeps old ledger entries explainable.
+func PutInput(table string, r Rule) *dynamodb.PutItemInput {
+	return &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"PK":            &types.AttributeValueMemberS{Value: configPK},
+			"SK":            &types.AttributeValueMemberS{Value: r.ID()},
+			"Type":          &types.AttributeValueMemberS{Value: "EARNING_RULE"},
+			"Material":      &types.AttributeValueMemberS{Value: string(r.Material)},
+			"PointsPerKg":   r.PointsPerKg.Attr(),
+			"MinKg":         r.MinKg.Attr(),
+			"Rounding":      &types.AttributeValueMemberS{Value: string(r.Rounding)},
+			"EffectiveFrom": &types.AttributeValueMemberS{Value: r.EffectiveFrom},
+			"CreatedBy":     &types.AttributeValueMemberS{Value: r.CreatedBy},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(SK)"),
+	}
+}
+
+func fromItem(item map[string]types.AttributeValue) (Rule, error) {
+	r := Rule{Rounding: RoundNone}
+	if v, ok := item["Material"].(*types.AttributeValueMemberS); ok {
+		r.Material = Material(v.Value)
+	}
+	if v, ok := item["Rounding"].(*types.AttributeValueMemberS); ok {
+		r.Rounding = Rounding(v.Value)
+	}
+	if v, ok := item["EffectiveFrom"].(*types.AttributeValueMemberS); ok {
+		r.EffectiveFrom = v.Value
+	}
+	if v, ok := item["CreatedBy"].(*types.AttributeValueMemberS); ok {
+		r.CreatedBy = v.Value
+	}
+	var err error
+	if r.PointsPerKg, err = amount.PointsAttr(item, "PointsPerKg"); err != nil {
+		return Rule{}, fmt.Errorf("rules: %s: %w", r.ID(), err)
+	}
+	if r.MinKg, err = amount.GramsAttr(item, "MinKg"); err != nil {
+		return Rule{}, fmt.Errorf("rules: %s: %w", r.ID(), err)
+	}
+	return r, nil
+}
@@ -0,0 +1,78 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"hello-world/internal/amount"
+)
+
+func TestPoints(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rule     Rule
+		kg       amount.Grams
+		expected amount.Points
+		err      error
+	}{
+		{name: "default rate", rule: DefaultRule(MaterialMixed), kg: 1500, expected: 15 * amount.Point},
+		{name: "fractional points are kept", rule: Rule{PointsPerKg: 7 * amount.Point}, kg: 100, expected: 70},
+		{name: "floor", rule: Rule{PointsPerKg: 12 * amount.Point, Rounding: RoundFloor}, kg: 750, expected: 9 * amount.Point},
+		{name: "round", rule: Rule{PointsPerKg: 7 * amount.Point, Rounding: RoundHalf}, kg: 500, expected: 4 * amount.Point},
+		{name: "ceil", rule: Rule{PointsPerKg: 7 * amount.Point, Rounding: RoundCeil}, kg: 100, expected: 1 * amount.Point},
+		{name: "below minimum", rule: Rule{PointsPerKg: 20 * amount.Point, MinKg: amount.Kg}, kg: 500, err: ErrBelowMinimum},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := testCase.rule.Points(testCase.kg)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected error %v, but got %v", testCase.err, err)
+			}
+			if got != testCase.expected {
+				t.Errorf("Expected %v points, but got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestActive(t *testing.T) {
+	all := []Rule{
+		{Material: MaterialPET, PointsPerKg: 15 * amount.Point, EffectiveFrom: "2024-01-01T00:00:00Z"},
+		{Material: MaterialPET, PointsPerKg: 20 * amount.Point, EffectiveFrom: "2024-06-01T00:00:00Z"},
+		{Material: MaterialPET, PointsPerKg: 25 * amount.Point, EffectiveFrom: "2030-01-01T00:00:00Z"},
+		{Material: MaterialHDPE, PointsPerKg: 30 * amount.Point, EffectiveFrom: "2024-01-01T00:00:00Z"},
+	}
+	at := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		material Material
+		expected amount.Points
+	}{
+		{MaterialPET, 20 * amount.Point},  // latest rule already in force, the 2030 one is not yet
+		{MaterialHDPE, 30 * amount.Point}, // single rule
+		{MaterialPP, 10 * amount.Point},   // nothing configured falls back to the default
+	}
+
+	for _, testCase := range testCases {
+		t.Run(string(testCase.material), func(t *testing.T) {
+			got := Active(all, testCase.material, at)
+			if got.PointsPerKg != testCase.expected {
+				t.Errorf("Expected %v points/kg, but got %v", testCase.expected, got.PointsPerKg)
+			}
+		})
+	}
+}
+
+func TestParseMaterial(t *testing.T) {
+	if m, err := ParseMaterial(""); err != nil || m != MaterialMixed {
+		t.Errorf("Expected empty material to default to MIXED, got %v, %v", m, err)
+	}
+	if m, err := ParseMaterial("pet"); err != nil || m != MaterialPET {
+		t.Errorf("Expected pet to parse as PET, got %v, %v", m, err)
+	}
+	if _, err := ParseMaterial("glass"); !errors.Is(err, ErrUnknownMaterial) {
+		t.Errorf("Expected ErrUnknownMaterial, got %v", err)
+	}
+}
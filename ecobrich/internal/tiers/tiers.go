@@ -0,0 +1,292 @@
+// Package tiers ranks members by the plastic they have recycled. A user's
+// tier follows PROFILE.TotalKg: it multiplies the points they earn and can
+// unlock vouchers reserved for higher tiers. The thresholds are stored under
+// PK=CONFIG so admins can change them without a deploy.
+package tiers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/ids"
+	"hello-world/internal/ledger"
+)
+
+const (
+	configPK   = "CONFIG"
+	tierPrefix = "TIER#"
+)
+
+// Tier is one level, stored as PK=CONFIG, SK=TIER#<name>.
+type Tier struct {
+	Name              string       `json:"name"`
+	MinKg             amount.Grams `json:"min_kg"`             // Lifetime kg needed to reach the tier
+	MultiplierPercent int64        `json:"multiplier_percent"` // 100 earns the base rate, 125 earns 1.25x
+	Perks             []string     `json:"perks"`
+}
+
+// Defaults apply until an admin saves a configuration.
+var Defaults = []Tier{
+	{Name: "Seed", MinKg: 0, MultiplierPercent: 100, Perks: []string{}},
+	{Name: "Sprout", MinKg: 10 * amount.Kg, MultiplierPercent: 110, Perks: []string{"10% bonus points"}},
+	{Name: "Tree", MinKg: 50 * amount.Kg, MultiplierPercent: 125, Perks: []string{"25% bonus points", "Tree vouchers"}},
+	{Name: "Forest", MinKg: 200 * amount.Kg, MultiplierPercent: 150, Perks: []string{"50% bonus points", "Tree and Forest vouchers"}},
+}
+
+// Apply multiplies points earned by a member of the tier.
+func (t Tier) Apply(p amount.Points) (amount.Points, error) {
+	return p.Percent(t.MultiplierPercent)
+}
+
+// Validate checks a full configuration submitted by an admin.
+func Validate(all []Tier) error {
+	if len(all) == 0 {
+		return errors.New("at least one tier is required")
+	}
+	names := map[string]bool{}
+	hasBase := false
+	for _, t := range all {
+		if strings.TrimSpace(t.Name) == "" || strings.Contains(t.Name, "#") {
+			return errors.New("tier names must be non-empty and must not contain #")
+		}
+		key := strings.ToLower(t.Name)
+		if names[key] {
+			return fmt.Errorf("duplicate tier %q", t.Name)
+		}
+		names[key] = true
+		if t.MinKg < 0 {
+			return fmt.Errorf("min_kg of %s must not be negative", t.Name)
+		}
+		if t.MinKg == 0 {
+			hasBase = true
+		}
+		if t.MultiplierPercent < 100 {
+			return fmt.Errorf("multiplier_percent of %s must be at least 100", t.Name)
+		}
+	}
+	if !hasBase {
+		return errors.New("one tier must start at min_kg 0")
+	}
+	return nil
+}
+
+// Sorted returns the tiers from lowest to highest threshold.
+func Sorted(all []Tier) []Tier {
+	out := append([]Tier(nil), all...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].MinKg < out[j].MinKg })
+	return out
+}
+
+// ForKg is the highest tier whose threshold kg reaches.
+func ForKg(all []Tier, kg amount.Grams) Tier {
+	sorted := Sorted(all)
+	best := sorted[0]
+	for _, t := range sorted {
+		if kg >= t.MinKg {
+			best = t
+		}
+	}
+	return best
+}
+
+// Find returns the tier called name, ignoring case.
+func Find(all []Tier, name string) (Tier, bool) {
+	for _, t := range all {
+		if strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return Tier{}, false
+}
+
+// Rank is the position of the named tier from the bottom, or -1.
+func Rank(all []Tier, name string) int {
+	for i, t := range Sorted(all) {
+		if strings.EqualFold(t.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Allows reports whether a member of userTier may take a voucher reserved
+// for minTier and above. Vouchers naming a tier that no longer exists stay
+// locked rather than opening up to everyone.
+func Allows(all []Tier, userTier, minTier string) bool {
+	if minTier == "" {
+		return true
+	}
+	need := Rank(all, minTier)
+	return need >= 0 && Rank(all, userTier) >= need
+}
+
+// Next is the tier above t, if any.
+func Next(all []Tier, t Tier) (Tier, bool) {
+	sorted := Sorted(all)
+	for i, s := range sorted {
+		if s.Name == t.Name && i+1 < len(sorted) {
+			return sorted[i+1], true
+		}
+	}
+	return Tier{}, false
+}
+
+// Current is the tier a profile holds. Profiles written before tiers
+// existed are placed by their TotalKg.
+func Current(all []Tier, p ledger.Profile) Tier {
+	if t, ok := Find(all, p.Tier); ok {
+		return t
+	}
+	return ForKg(all, p.TotalKg)
+}
+
+// Change is a promotion or demotion.
+type Change struct {
+	From      Tier
+	To        Tier
+	Promotion bool
+}
+
+// Evaluate decides the tier of a user whose lifetime kg becomes kg. The
+// change is nil when the stored tier already matches, and also for the
+// first assignment of a profile that never had a tier.
+func Evaluate(all []Tier, stored string, kg amount.Grams) (Tier, *Change) {
+	to := ForKg(all, kg)
+	if stored == "" || strings.EqualFold(stored, to.Name) {
+		return to, nil
+	}
+	from, ok := Find(all, stored)
+	if !ok {
+		// The stored tier was removed from the configuration
+		from = Tier{Name: stored}
+		return to, &Change{From: from, To: to, Promotion: false}
+	}
+	return to, &Change{From: from, To: to, Promotion: to.MinKg > from.MinKg}
+}
+
+// HistoryItem records c in the user's history. It goes in the same
+// transaction as the PROFILE update that stores the new tier.
+func (c Change) HistoryItem(table, userID string, kg amount.Grams, now time.Time) types.TransactWriteItem {
+	verb := "Demoted"
+	if c.Promotion {
+		verb = "Promoted"
+	}
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"PK":        &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+			"SK":        &types.AttributeValueMemberS{Value: "TIER#" + ids.NewAt(now)},
+			"Type":      &types.AttributeValueMemberS{Value: string(ledger.TypeTierChange)},
+			"FromTier":  &types.AttributeValueMemberS{Value: c.From.Name},
+			"ToTier":    &types.AttributeValueMemberS{Value: c.To.Name},
+			"TotalKg":   kg.Attr(),
+			"Note":      &types.AttributeValueMemberS{Value: fmt.Sprintf("%s from %s to %s", verb, c.From.Name, c.To.Name)},
+			"Status":    &types.AttributeValueMemberS{Value: ledger.StatusApproved},
+			"CreatedAt": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		},
+	}}
+}
+
+// Load reads the configured tiers, or Defaults when none are stored.
+func Load(ctx context.Context, api dynamodb.QueryAPIClient, table string) ([]Tier, error) {
+	p := dynamodb.NewQueryPaginator(api, &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :t)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: configPK},
+			":t":  &types.AttributeValueMemberS{Value: tierPrefix},
+		},
+	})
+
+	var all []Tier
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			t, err := fromItem(item)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, t)
+		}
+	}
+	if len(all) == 0 {
+		return Sorted(Defaults), nil
+	}
+	return Sorted(all), nil
+}
+
+// ReplaceItems stores next as the whole configuration, deleting the tiers
+// of prev that are no longer present.
+func ReplaceItems(table string, prev, next []Tier) []types.TransactWriteItem {
+	var items []types.TransactWriteItem
+	keep := map[string]bool{}
+	for _, t := range next {
+		keep[t.Name] = true
+		perks := make([]types.AttributeValue, len(t.Perks))
+		for i, p := range t.Perks {
+			perks[i] = &types.AttributeValueMemberS{Value: p}
+		}
+		items = append(items, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String(table),
+			Item: map[string]types.AttributeValue{
+				"PK":                &types.AttributeValueMemberS{Value: configPK},
+				"SK":                &types.AttributeValueMemberS{Value: tierPrefix + t.Name},
+				"Type":              &types.AttributeValueMemberS{Value: "TIER"},
+				"Name":              &types.AttributeValueMemberS{Value: t.Name},
+				"MinKg":             t.MinKg.Attr(),
+				"MultiplierPercent": &types.AttributeValueMemberN{Value: strconv.FormatInt(t.MultiplierPercent, 10)},
+				"Perks":             &types.AttributeValueMemberL{Value: perks},
+			},
+		}})
+	}
+	for _, t := range prev {
+		if keep[t.Name] {
+			continue
+		}
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName: aws.String(table),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: configPK},
+				"SK": &types.AttributeValueMemberS{Value: tierPrefix + t.Name},
+			},
+		}})
+	}
+	return items
+}
+
+func fromItem(item map[string]types.AttributeValue) (Tier, error) {
+	t := Tier{MultiplierPercent: 100, Perks: []string{}}
+	if v, ok := item["Name"].(*types.AttributeValueMemberS); ok {
+		t.Name = v.Value
+	}
+	var err error
+	if t.MinKg, err = amount.GramsAttr(item, "MinKg"); err != nil {
+		return Tier{}, fmt.Errorf("tiers: %s: %w", t.Name, err)
+	}
+	if v, ok := item["MultiplierPercent"].(*types.AttributeValueMemberN); ok {
+		if t.MultiplierPercent, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+			return Tier{}, fmt.Errorf("tiers: %s: MultiplierPercent %q: %w", t.Name, v.Value, err)
+		}
+	}
+	if v, ok := item["Perks"].(*types.AttributeValueMemberL); ok {
+		for _, p := range v.Value {
+			if s, ok := p.(*types.AttributeValueMemberS); ok {
+				t.Perks = append(t.Perks, s.Value)
+			}
+		}
+	}
+	return t, nil
+}
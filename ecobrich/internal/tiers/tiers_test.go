@@ -0,0 +1,91 @@
+package tiers
+
+import (
+	"testing"
+
+	"hello-world/internal/amount"
+)
+
+func TestEvaluate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		stored    string
+		kg        amount.Grams
+		expected  string
+		changed   bool
+		promotion bool
+	}{
+		{name: "first assignment is silent", stored: "", kg: 12 * amount.Kg, expected: "Sprout"},
+		{name: "same tier", stored: "Sprout", kg: 49 * amount.Kg, expected: "Sprout"},
+		{name: "threshold reached", stored: "Sprout", kg: 50 * amount.Kg, expected: "Tree", changed: true, promotion: true},
+		{name: "skips a tier", stored: "Seed", kg: 250 * amount.Kg, expected: "Forest", changed: true, promotion: true},
+		{name: "thresholds raised", stored: "Tree", kg: 20 * amount.Kg, expected: "Sprout", changed: true},
+		{name: "stored tier removed", stored: "Bush", kg: 0, expected: "Seed", changed: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, change := Evaluate(Defaults, testCase.stored, testCase.kg)
+			if got.Name != testCase.expected {
+				t.Errorf("Expected %s, but got %s", testCase.expected, got.Name)
+			}
+			if (change != nil) != testCase.changed {
+				t.Fatalf("Expected changed %v, but got %+v", testCase.changed, change)
+			}
+			if change != nil && change.Promotion != testCase.promotion {
+				t.Errorf("Expected promotion %v, but got %v", testCase.promotion, change.Promotion)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		tiers []Tier
+		valid bool
+	}{
+		{name: "defaults", tiers: Defaults, valid: true},
+		{name: "empty", tiers: nil},
+		{name: "no base tier", tiers: []Tier{{Name: "Tree", MinKg: amount.Kg, MultiplierPercent: 100}}},
+		{name: "duplicate", tiers: []Tier{{Name: "Seed", MultiplierPercent: 100}, {Name: "seed", MinKg: amount.Kg, MultiplierPercent: 100}}},
+		{name: "multiplier below base", tiers: []Tier{{Name: "Seed", MultiplierPercent: 90}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := Validate(testCase.tiers); (err == nil) != testCase.valid {
+				t.Errorf("Expected valid %v, but got %v", testCase.valid, err)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tree, _ := Find(Defaults, "tree")
+	if got, _ := tree.Apply(10 * amount.Point); got != 1250 {
+		t.Errorf("Expected 12.5 points, but got %v", got)
+	}
+	if Rank(Defaults, "Forest") <= Rank(Defaults, "Tree") || Rank(Defaults, "Bush") != -1 {
+		t.Errorf("Unexpected ranks")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	testCases := []struct {
+		user, min string
+		expected  bool
+	}{
+		{"Seed", "", true},
+		{"Forest", "Tree", true},
+		{"Tree", "Tree", true},
+		{"Sprout", "Tree", false},
+		{"", "Seed", false},       // anonymous
+		{"Forest", "Bush", false}, // tier removed from the configuration
+	}
+	for _, testCase := range testCases {
+		if got := Allows(Defaults, testCase.user, testCase.min); got != testCase.expected {
+			t.Errorf("Allows(%q, %q): expected %v, but got %v", testCase.user, testCase.min, testCase.expected, got)
+		}
+	}
+}
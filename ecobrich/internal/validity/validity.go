@@ -0,0 +1,104 @@
+// Package validity interprets the StartsAt and ExpiresAt of vouchers. Both
+// are stored as RFC3339; definitions created before they were validated may
+// hold a bare YYYY-MM-DD date, or free text, which never expires.
+package validity
+
+import (
+	"errors"
+	"time"
+)
+
+// State is where a voucher stands relative to its window.
+type State string
+
+const (
+	Scheduled State = "scheduled" // StartsAt is in the future
+	Active    State = "active"
+	Expired   State = "expired"
+)
+
+const dateLayout = "2006-01-02"
+
+var ErrInvalid = errors.New("must be an RFC3339 timestamp or a YYYY-MM-DD date")
+
+// Parse reads a bound. A bare date is the start of that day, or its last
+// second when endOfDay is set, in UTC.
+func Parse(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return time.Time{}, ErrInvalid
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// Normalize parses a bound submitted by an admin into the stored form. An
+// empty string stays empty: the window is open on that side.
+func Normalize(s string, endOfDay bool) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	t, err := Parse(s, endOfDay)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// Window is the period a voucher can be redeemed or used. A zero bound is
+// open.
+type Window struct {
+	StartsAt  time.Time
+	ExpiresAt time.Time
+}
+
+// FromStrings reads stored bounds, leniently: a bound that cannot be parsed
+// is treated as open.
+func FromStrings(startsAt, expiresAt string) Window {
+	var w Window
+	w.StartsAt, _ = Parse(startsAt, false)
+	w.ExpiresAt, _ = Parse(expiresAt, true)
+	return w
+}
+
+// At returns the state of the window at t. ExpiresAt is the last valid
+// instant.
+func (w Window) At(t time.Time) State {
+	switch {
+	case !w.ExpiresAt.IsZero() && t.After(w.ExpiresAt):
+		return Expired
+	case !w.StartsAt.IsZero() && t.Before(w.StartsAt):
+		return Scheduled
+	}
+	return Active
+}
+
+// Validate checks a window submitted by an admin.
+func (w Window) Validate(now time.Time) error {
+	if !w.StartsAt.IsZero() && !w.ExpiresAt.IsZero() && !w.ExpiresAt.After(w.StartsAt) {
+		return errors.New("expires_at must be after starts_at")
+	}
+	if !w.ExpiresAt.IsZero() && !w.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// NoExpiry sorts vouchers that never expire after every dated one.
+const NoExpiry = "9999-12-31T23:59:59Z"
+
+// SortKey is the ExpirySort attribute of a definition: its expiry in UTC
+// RFC3339, so string order is time order, or NoExpiry when it has none or
+// it cannot be parsed.
+func SortKey(expiresAt string) string {
+	s, err := Normalize(expiresAt, true)
+	if err != nil || s == "" {
+		return NoExpiry
+	}
+	return s
+}
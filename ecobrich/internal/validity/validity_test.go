@@ -0,0 +1,86 @@
+package validity
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		in       string
+		endOfDay bool
+		expected string
+		err      error
+	}{
+		{in: "", expected: ""},
+		{in: "2026-12-31", endOfDay: true, expected: "2026-12-31T23:59:59Z"},
+		{in: "2026-12-31", expected: "2026-12-31T00:00:00Z"},
+		{in: "2026-12-31T10:00:00+07:00", expected: "2026-12-31T03:00:00Z"},
+		{in: "31/12/2026", err: ErrInvalid},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.in, func(t *testing.T) {
+			got, err := Normalize(testCase.in, testCase.endOfDay)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected error %v, but got %v", testCase.err, err)
+			}
+			if got != testCase.expected {
+				t.Errorf("Expected %q, but got %q", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestAt(t *testing.T) {
+	w := FromStrings("2026-06-01T00:00:00Z", "2026-06-30")
+	testCases := []struct {
+		name     string
+		window   Window
+		at       time.Time
+		expected State
+	}{
+		{"before the start", w, time.Date(2026, 5, 31, 23, 0, 0, 0, time.UTC), Scheduled},
+		{"first instant", w, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), Active},
+		{"last day is valid", w, time.Date(2026, 6, 30, 23, 59, 59, 0, time.UTC), Active},
+		{"after the end", w, time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), Expired},
+		{"legacy free text never expires", FromStrings("", "when stocks last"), time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), Active},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := testCase.window.At(testCase.at); got != testCase.expected {
+				t.Errorf("Expected %s, but got %s", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := FromStrings("2026-02-01", "2026-01-15").Validate(now); err == nil {
+		t.Errorf("Expected an error for a window ending before it starts")
+	}
+	if err := FromStrings("", "2025-12-31").Validate(now); err == nil {
+		t.Errorf("Expected an error for a window already over")
+	}
+	if err := FromStrings("", "").Validate(now); err != nil {
+		t.Errorf("Expected an open window to be valid, but got %v", err)
+	}
+}
+
+func TestSortKey(t *testing.T) {
+	testCases := []struct {
+		expiresAt string
+		expected  string
+	}{
+		{"2026-12-31T10:00:00+07:00", "2026-12-31T03:00:00Z"},
+		{"2026-12-31", "2026-12-31T23:59:59Z"},
+		{"", NoExpiry},
+		{"when stocks last", NoExpiry},
+	}
+	for _, testCase := range testCases {
+		if got := SortKey(testCase.expiresAt); got != testCase.expected {
+			t.Errorf("SortKey(%q): expected %q, but got %q", testCase.expiresAt, testCase.expected, got)
+		}
+	}
+}
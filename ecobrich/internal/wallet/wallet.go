@@ -0,0 +1,123 @@
+// Package wallet reads the vouchers a member holds: the USER_VOUCHER items
+// redeem writes under SK=VOUCHER#<ulid>, each a copy of the definition at
+// redemption time. A voucher starts active (ClaimedAt is its CreatedAt)
+// and ends either used, when a partner consumes it (UsedAt), or expired,
+// when the expiry job passes its ExpiresAt (ExpiredAt). An admin reversing
+// the redemption voids a voucher that was not used (VoidedAt). The ends are
+// final and every transition is a conditional update.
+package wallet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/discount"
+	"hello-world/internal/validity"
+)
+
+const (
+	SKPrefix = "VOUCHER#"
+	Type     = "USER_VOUCHER"
+)
+
+const (
+	StatusActive  = "active"
+	StatusUsed    = "used"
+	StatusExpired = "expired"
+	StatusVoid    = "void" // Redemption reversed, points refunded
+)
+
+// IsStatus reports whether s is a wallet status.
+func IsStatus(s string) bool {
+	return s == StatusActive || s == StatusUsed || s == StatusExpired || s == StatusVoid
+}
+
+type Voucher struct {
+	ID             string             `json:"id"`
+	VoucherID      string             `json:"voucher_id"` // The definition it was redeemed from
+	Code           string             `json:"code"`
+	Title          string             `json:"title"`
+	Discount       string             `json:"discount"`
+	DiscountRule   *discount.Discount `json:"discount_rule,omitempty"`
+	PointsRequired amount.Points      `json:"points_required"`
+	Status         string             `json:"status"`
+	ClaimedAt      string             `json:"claimed_at"`
+	ExpiresAt      string             `json:"expires_at,omitempty"`
+	UsedAt         string             `json:"used_at,omitempty"`
+	ExpiredAt      string             `json:"expired_at,omitempty"`
+	VoidedAt       string             `json:"voided_at,omitempty"`
+	VoidReason     string             `json:"void_reason,omitempty"`
+	OrderRef       string             `json:"order_ref,omitempty"`    // The partner's receipt, once used
+	DiscountVND    *int64             `json:"discount_vnd,omitempty"` // What the partner took off, when it priced the order
+}
+
+// SK is the sort key of the wallet voucher with id.
+func SK(id string) string {
+	return SKPrefix + strings.TrimPrefix(id, SKPrefix)
+}
+
+// FromItem decodes a USER_VOUCHER item. Items written before statuses were
+// tracked count as active.
+func FromItem(item map[string]types.AttributeValue) (Voucher, error) {
+	v := Voucher{
+		ID:         strings.TrimPrefix(stringAttr(item, "SK"), SKPrefix),
+		VoucherID:  strings.TrimPrefix(stringAttr(item, "VoucherRef"), "DEF#"),
+		Code:       stringAttr(item, "Code"),
+		Title:      stringAttr(item, "Title"),
+		Discount:   stringAttr(item, "Discount"),
+		Status:     stringAttr(item, "Status"),
+		ClaimedAt:  stringAttr(item, "CreatedAt"),
+		ExpiresAt:  stringAttr(item, "ExpiresAt"),
+		UsedAt:     stringAttr(item, "UsedAt"),
+		ExpiredAt:  stringAttr(item, "ExpiredAt"),
+		VoidedAt:   stringAttr(item, "VoidedAt"),
+		VoidReason: stringAttr(item, "VoidReason"),
+		OrderRef:   stringAttr(item, "OrderRef"),
+	}
+	if v.Status == "" {
+		v.Status = StatusActive
+	}
+	var err error
+	if v.PointsRequired, err = amount.PointsAttr(item, "PointsRequired"); err != nil {
+		return v, fmt.Errorf("wallet: voucher %s: %w", v.ID, err)
+	}
+	if rule, ok, err := discount.FromItem(item, "DiscountRule"); err != nil {
+		return v, fmt.Errorf("wallet: voucher %s: %w", v.ID, err)
+	} else if ok {
+		v.DiscountRule = &rule
+	}
+	if n, ok := item["DiscountVND"].(*types.AttributeValueMemberN); ok {
+		off, err := strconv.ParseInt(n.Value, 10, 64)
+		if err != nil {
+			return v, fmt.Errorf("wallet: voucher %s: DiscountVND %q", v.ID, n.Value)
+		}
+		v.DiscountVND = &off
+	}
+	return v, nil
+}
+
+// Refresh reports an active voucher past its expiry as expired, as the
+// daily job will record it; the job gives items without a Status the active
+// one first. ExpiredAt is then the expiry itself.
+func (v *Voucher) Refresh(now time.Time) {
+	if v.Status != StatusActive {
+		return
+	}
+	w := validity.FromStrings("", v.ExpiresAt)
+	if w.At(now) == validity.Expired {
+		v.Status = StatusExpired
+		v.ExpiredAt = w.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
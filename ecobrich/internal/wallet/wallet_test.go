@@ -0,0 +1,61 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestFromItem(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"SK":             &types.AttributeValueMemberS{Value: "VOUCHER#01J0000000000000000000000"},
+		"Type":           &types.AttributeValueMemberS{Value: Type},
+		"VoucherRef":     &types.AttributeValueMemberS{Value: "DEF#01H0000000000000000000000"},
+		"Code":           &types.AttributeValueMemberS{Value: "ECO-1"},
+		"PointsRequired": &types.AttributeValueMemberN{Value: "50"},
+		"CreatedAt":      &types.AttributeValueMemberS{Value: "2026-05-01T10:00:00Z"},
+		"DiscountVND":    &types.AttributeValueMemberN{Value: "20000"},
+	}
+	v, err := FromItem(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != "01J0000000000000000000000" || v.VoucherID != "01H0000000000000000000000" {
+		t.Errorf("Expected ids without prefixes, but got %q and %q", v.ID, v.VoucherID)
+	}
+	if v.Status != StatusActive {
+		t.Errorf("Expected a voucher without status to be active, but got %q", v.Status)
+	}
+	if v.ClaimedAt != "2026-05-01T10:00:00Z" {
+		t.Errorf("Expected claimed_at from CreatedAt, but got %q", v.ClaimedAt)
+	}
+	if v.DiscountVND == nil || *v.DiscountVND != 20000 {
+		t.Errorf("Expected discount_vnd 20000, but got %v", v.DiscountVND)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name      string
+		voucher   Voucher
+		status    string
+		expiredAt string
+	}{
+		{"active and valid", Voucher{Status: StatusActive, ExpiresAt: "2026-06-30T00:00:00Z"}, StatusActive, ""},
+		{"active without expiry", Voucher{Status: StatusActive}, StatusActive, ""},
+		{"active past expiry", Voucher{Status: StatusActive, ExpiresAt: "2026-05-31"}, StatusExpired, "2026-05-31T23:59:59Z"},
+		{"used stays used", Voucher{Status: StatusUsed, ExpiresAt: "2026-05-31"}, StatusUsed, ""},
+		{"already expired", Voucher{Status: StatusExpired, ExpiredAt: "2026-06-01T00:05:00Z"}, StatusExpired, "2026-06-01T00:05:00Z"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			v := testCase.voucher
+			v.Refresh(now)
+			if v.Status != testCase.status || v.ExpiredAt != testCase.expiredAt {
+				t.Errorf("Expected %s at %q, but got %s at %q", testCase.status, testCase.expiredAt, v.Status, v.ExpiredAt)
+			}
+		})
+	}
+}
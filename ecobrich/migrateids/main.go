@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/dberr"
+	"hello-world/internal/ids"
+)
+
+// MigrateEvent is the input of a manual run. A dry run only reports what
+// would be rewritten.
+type MigrateEvent struct {
+	DryRun bool `json:"dry_run"`
+}
+
+type Report struct {
+	DryRun   bool     `json:"dry_run"`
+	Scanned  int      `json:"scanned"`
+	Migrated int      `json:"migrated"`
+	Skipped  []string `json:"skipped"`
+}
+
+// prefixes whose SK used to be "<prefix>" + time.RFC3339
+var prefixes = []string{"TRANS#", "REDEEM#"}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+// handleRequest moves history items keyed by an RFC3339 timestamp to a ULID
+// key generated from the same instant, so old and new items sort together.
+// The old key is kept in LegacySK and ledger.GetItem still resolves it.
+// The job is safe to re-run: migrated items no longer match.
+func handleRequest(ctx context.Context, event MigrateEvent) (Report, error) {
+	report := Report{DryRun: event.DryRun, Skipped: []string{}}
+
+	p := dynamodb.NewScanPaginator(dbClient, &dynamodb.ScanInput{
+		TableName:        aws.String(tableName),
+		FilterExpression: aws.String("begins_with(PK, :u) AND (begins_with(SK, :trans) OR begins_with(SK, :redeem))"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u":      &types.AttributeValueMemberS{Value: "USER#"},
+			":trans":  &types.AttributeValueMemberS{Value: prefixes[0]},
+			":redeem": &types.AttributeValueMemberS{Value: prefixes[1]},
+		},
+	})
+
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return report, err
+		}
+		for _, item := range out.Items {
+			report.Scanned++
+			sk := item["SK"].(*types.AttributeValueMemberS).Value
+			if !ids.IsLegacySK(sk) {
+				continue
+			}
+			if event.DryRun {
+				report.Migrated++
+				continue
+			}
+			if err := migrate(ctx, item, sk); err != nil {
+				fmt.Println("Skip", sk, err)
+				report.Skipped = append(report.Skipped, sk)
+				continue
+			}
+			report.Migrated++
+		}
+	}
+	return report, nil
+}
+
+func migrate(ctx context.Context, item map[string]types.AttributeValue, oldSK string) error {
+	t, _ := ids.TimeOfSK(oldSK)
+	prefix := oldSK[:strings.IndexByte(oldSK, '#')+1]
+	newSK := prefix + ids.NewAt(t)
+
+	moved := make(map[string]types.AttributeValue, len(item)+1)
+	for k, v := range item {
+		moved[k] = v
+	}
+	moved["SK"] = &types.AttributeValueMemberS{Value: newSK}
+	moved["LegacySK"] = &types.AttributeValueMemberS{Value: oldSK}
+	if _, ok := moved["CreatedAt"]; !ok {
+		moved["CreatedAt"] = &types.AttributeValueMemberS{Value: t.Format(time.RFC3339)}
+	}
+
+	// Copy and delete atomically; the delete is conditioned on the status
+	// being unchanged so a concurrent approval is not lost.
+	del := &types.Delete{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": item["PK"],
+			"SK": item["SK"],
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#status)"),
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+	}
+	if status, ok := item["Status"]; ok {
+		del.ConditionExpression = aws.String("#status = :status")
+		del.ExpressionAttributeValues = map[string]types.AttributeValue{":status": status}
+	}
+
+	_, err := dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:           aws.String(tableName),
+					Item:                moved,
+					ConditionExpression: aws.String("attribute_not_exists(SK)"),
+				},
+			},
+			{Delete: del},
+		},
+	})
+	if dberr.ConditionFailed(err) {
+		return fmt.Errorf("item changed during migration, re-run to retry")
+	}
+	return err
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/authz"
+	"hello-world/internal/codes"
+	"hello-world/internal/dberr"
+	"hello-world/internal/discount"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ledger"
+	"hello-world/internal/validity"
+	"hello-world/internal/wallet"
+)
+
+// VoucherRequest identifies the voucher shown at checkout. UserID is only
+// needed for vouchers with a shared code; pooled codes know their owner.
+type VoucherRequest struct {
+	Code     string `json:"code"`
+	UserID   string `json:"user_id,omitempty"`
+	OrderRef string `json:"order_ref,omitempty"` // Consume only: the shop's receipt number
+	// Cart prices the order: the response then says how much the voucher
+	// takes off, and an order that does not qualify is refused
+	Cart *discount.Cart `json:"cart,omitempty"`
+}
+
+type VoucherResponse struct {
+	Message   string `json:"message"`
+	Valid     bool   `json:"valid"`
+	Code      string `json:"code"`
+	Title     string `json:"title"`
+	Discount  string `json:"discount"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Status    string `json:"status"`
+	UsedAt    string `json:"used_at,omitempty"`
+
+	DiscountRule *discount.Discount `json:"discount_rule,omitempty"`
+	DiscountVND  *int64             `json:"discount_vnd,omitempty"` // Only when a cart was sent
+}
+
+// userVoucher is a USER_VOUCHER item found from a code.
+type userVoucher struct {
+	UserID    string
+	SK        string
+	Pooled    bool
+	Item      map[string]types.AttributeValue
+	Code      string
+	Status    string
+	ExpiresAt string
+	Rule      *discount.Discount // nil for vouchers whose Discount cannot be read as a rule
+}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+
+	// 1. Authorization Check: partner shops (and Admins) accept vouchers
+	caller, err := authz.Authorize(request, authz.PermValidateVouchers)
+	if err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
+	}
+
+	var body VoucherRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return response(400, "Invalid request body"), nil
+	}
+	body.Code = codes.Normalize(body.Code)
+	if body.Code == "" {
+		return response(400, "Missing code"), nil
+	}
+	if body.Cart != nil {
+		if err := body.Cart.Validate(); err != nil {
+			return response(400, err.Error()), nil
+		}
+	}
+
+	// 2. Find the voucher the code belongs to
+	uv, res, ok := findVoucher(ctx, body)
+	if !ok {
+		return res, nil
+	}
+
+	now := time.Now()
+	if res, rejected := unusable(uv, now); rejected {
+		return res, nil
+	}
+
+	// 3. Price the order
+	var off *int64
+	if body.Cart != nil {
+		if uv.Rule == nil {
+			return jsonResponse(422, toResponse(uv, "Voucher has no discount rule to price the order", false)), nil
+		}
+		n, err := uv.Rule.Apply(*body.Cart)
+		if errors.Is(err, discount.ErrMinOrder) || errors.Is(err, discount.ErrNoEligible) {
+			return jsonResponse(422, toResponse(uv, "Order does not qualify: "+err.Error(), false)), nil
+		}
+		if err != nil {
+			fmt.Println("Discount Error:", uv.SK, err)
+			return response(500, "System Error: Failed to price the order"), nil
+		}
+		off = &n
+	}
+
+	if strings.HasSuffix(request.Resource, "/validate") {
+		res := toResponse(uv, "Voucher is valid", true)
+		res.DiscountVND = off
+		return jsonResponse(200, res), nil
+	}
+	if strings.HasSuffix(request.Resource, "/consume") {
+		return consume(ctx, uv, caller, body.OrderRef, off, now)
+	}
+	return response(404, "Not Found"), nil
+}
+
+// consume marks the voucher used. The condition on Status is what stops two
+// tills from accepting the same code.
+func consume(ctx context.Context, uv userVoucher, caller authz.Identity, orderRef string, off *int64, nowTime time.Time) (events.APIGatewayProxyResponse, error) {
+	now := nowTime.Format(time.RFC3339)
+	update := "SET #status = :used, UsedAt = :t, UsedBy = :merchant, MerchantEmail = :email, OrderRef = :order"
+	values := map[string]types.AttributeValue{
+		":used":     &types.AttributeValueMemberS{Value: wallet.StatusUsed},
+		":active":   &types.AttributeValueMemberS{Value: wallet.StatusActive},
+		":t":        &types.AttributeValueMemberS{Value: now},
+		":merchant": &types.AttributeValueMemberS{Value: caller.UserID},
+		":email":    &types.AttributeValueMemberS{Value: caller.Email},
+		":order":    &types.AttributeValueMemberS{Value: orderRef},
+	}
+	if off != nil {
+		update += ", DiscountVND = :off"
+		values[":off"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*off, 10)}
+	}
+	items := []types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(uv.UserID)},
+				"SK": &types.AttributeValueMemberS{Value: uv.SK},
+			},
+			UpdateExpression:    aws.String(update),
+			ConditionExpression: aws.String("attribute_not_exists(#status) OR #status = :active"),
+			ExpressionAttributeNames: map[string]string{
+				"#status": "Status",
+			},
+			ExpressionAttributeValues: values,
+		},
+	}}
+	if uv.Pooled {
+		items = append(items, types.TransactWriteItem{Update: &types.Update{
+			TableName:           aws.String(tableName),
+			Key:                 codes.Key(uv.Code),
+			UpdateExpression:    aws.String("SET #status = :used, UsedAt = :t, UsedBy = :merchant"),
+			ConditionExpression: aws.String("#status = :claimed"),
+			ExpressionAttributeNames: map[string]string{
+				"#status": "Status",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":used":     &types.AttributeValueMemberS{Value: codes.StatusUsed},
+				":claimed":  &types.AttributeValueMemberS{Value: codes.StatusClaimed},
+				":t":        &types.AttributeValueMemberS{Value: now},
+				":merchant": &types.AttributeValueMemberS{Value: caller.UserID},
+			},
+		}})
+	}
+
+	_, err := dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if dberr.ConditionFailed(err) {
+		// Used, voided by a reversal or expired since it was read: say which
+		if err := loadVoucher(ctx, &uv); err != nil {
+			fmt.Println("DynamoDB Error:", err)
+			return response(500, "System Error: Failed to load voucher"), nil
+		}
+		if res, rejected := unusable(uv, nowTime); rejected {
+			return res, nil
+		}
+		return jsonResponse(409, toResponse(uv, "Voucher changed, please try again", false)), nil
+	}
+	if err != nil {
+		fmt.Println("DynamoDB Transaction Error:", err)
+		return response(500, "System Error: Failed to consume voucher"), nil
+	}
+
+	uv.Status = wallet.StatusUsed
+	uv.Item["UsedAt"] = &types.AttributeValueMemberS{Value: now}
+	res := toResponse(uv, "Voucher consumed", true)
+	res.DiscountVND = off
+	return jsonResponse(200, res), nil
+}
+
+// findVoucher resolves a code to the USER_VOUCHER holding it: through the
+// pool for unique codes, or in the given user's vouchers for shared codes.
+func findVoucher(ctx context.Context, body VoucherRequest) (userVoucher, events.APIGatewayProxyResponse, bool) {
+	out, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            codes.Key(body.Code),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return userVoucher{}, response(500, "System Error: Failed to look up code"), false
+	}
+
+	uv := userVoucher{UserID: body.UserID, Code: body.Code}
+	if out.Item != nil {
+		if stringAttr(out.Item, "Status") == codes.StatusAvailable {
+			return userVoucher{}, response(404, "Code has not been redeemed by a member"), false
+		}
+		owner := stringAttr(out.Item, "UserID")
+		if body.UserID != "" && body.UserID != owner {
+			return userVoucher{}, response(403, "Code belongs to another member"), false
+		}
+		uv.UserID, uv.SK, uv.Pooled = owner, stringAttr(out.Item, "UserVoucherSK"), true
+	} else {
+		// Shared codes are the same for every redeemer, so the owner must be named
+		if body.UserID == "" {
+			return userVoucher{}, response(400, "user_id is required for this code"), false
+		}
+		if uv.SK, err = findSharedCode(ctx, body.UserID, body.Code); err != nil {
+			fmt.Println("DynamoDB Error:", err)
+			return userVoucher{}, response(500, "System Error: Failed to look up code"), false
+		}
+		if uv.SK == "" {
+			return userVoucher{}, response(404, "Voucher not found"), false
+		}
+	}
+
+	if err := loadVoucher(ctx, &uv); err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return userVoucher{}, response(500, "System Error: Failed to load voucher"), false
+	}
+	if uv.Item == nil {
+		return userVoucher{}, response(404, "Voucher not found"), false
+	}
+	return uv, events.APIGatewayProxyResponse{}, true
+}
+
+// loadVoucher reads the USER_VOUCHER at uv.UserID and uv.SK into uv. Item
+// stays nil if it does not exist.
+func loadVoucher(ctx context.Context, uv *userVoucher) error {
+	item, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(uv.UserID)},
+			"SK": &types.AttributeValueMemberS{Value: uv.SK},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	if item.Item == nil {
+		return nil
+	}
+	uv.Item = item.Item
+	uv.Status = stringAttr(item.Item, "Status")
+	if uv.Status == "" {
+		// Items written before statuses were tracked, as in package wallet
+		uv.Status = wallet.StatusActive
+	}
+	uv.ExpiresAt = stringAttr(item.Item, "ExpiresAt")
+	// Vouchers redeemed before discount rules only carry the display string
+	rule, ok, err := discount.FromItem(item.Item, "DiscountRule")
+	if !ok {
+		rule, err = discount.Parse(stringAttr(item.Item, "Discount"))
+	}
+	if err == nil {
+		uv.Rule = &rule
+	}
+	return nil
+}
+
+// unusable returns the rejection for a voucher that cannot be accepted at
+// now, with rejected false when it can.
+func unusable(uv userVoucher, now time.Time) (res events.APIGatewayProxyResponse, rejected bool) {
+	switch {
+	case uv.Status == wallet.StatusUsed:
+		return jsonResponse(409, toResponse(uv, "Voucher has already been used", false)), true
+	case uv.Status == wallet.StatusVoid:
+		return jsonResponse(409, toResponse(uv, "Voucher has been voided", false)), true
+	case uv.Status == wallet.StatusExpired || expired(uv.ExpiresAt, now):
+		return jsonResponse(410, toResponse(uv, "Voucher has expired", false)), true
+	case uv.Status != wallet.StatusActive:
+		return jsonResponse(409, toResponse(uv, "Voucher is "+uv.Status, false)), true
+	}
+	return events.APIGatewayProxyResponse{}, false
+}
+
+// findSharedCode returns the SK of the user's voucher with code, preferring
+// one still active when the user redeemed the same voucher more than once.
+func findSharedCode(ctx context.Context, userID, code string) (string, error) {
+	p := dynamodb.NewQueryPaginator(dbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :v)"),
+		FilterExpression:       aws.String("Code = :code"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":   &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+			":v":    &types.AttributeValueMemberS{Value: wallet.SKPrefix},
+			":code": &types.AttributeValueMemberS{Value: code},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+
+	found := ""
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range out.Items {
+			sk := stringAttr(item, "SK")
+			if stringAttr(item, "Status") == wallet.StatusActive {
+				return sk, nil
+			}
+			if found == "" {
+				found = sk
+			}
+		}
+	}
+	return found, nil
+}
+
+// expired applies the same window as redeem and the expiry job, so a
+// voucher the member can still see is one the partner can still accept.
+func expired(expiresAt string, now time.Time) bool {
+	return validity.FromStrings("", expiresAt).At(now) == validity.Expired
+}
+
+func toResponse(uv userVoucher, message string, valid bool) VoucherResponse {
+	return VoucherResponse{
+		Message:   message,
+		Valid:     valid,
+		Code:      uv.Code,
+		Title:     stringAttr(uv.Item, "Title"),
+		Discount:  stringAttr(uv.Item, "Discount"),
+		ExpiresAt: uv.ExpiresAt,
+		Status:    uv.Status,
+		UsedAt:    stringAttr(uv.Item, "UsedAt"),
+
+		DiscountRule: uv.Rule,
+	}
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
+		"Access-Control-Allow-Methods": "POST,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
+}
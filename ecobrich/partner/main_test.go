@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHandler(t *testing.T) {
+	withGroups := func(groups string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Resource:   "/partner/vouchers/validate",
+			Body:       body,
+			RequestContext: events.APIGatewayProxyRequestContext{
+				Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "shop-1", "cognito:groups": groups}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		request        events.APIGatewayProxyRequest
+		expectedStatus int
+	}{
+		{
+			name:           "missing claims",
+			request:        events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: `{"code":"ECO-1"}`},
+			expectedStatus: 401,
+		},
+		{
+			name:           "member without the Partner group",
+			request:        withGroups("", `{"code":"ECO-1"}`),
+			expectedStatus: 403,
+		},
+		{
+			name:           "missing code",
+			request:        withGroups("Partner", `{"code":"  "}`),
+			expectedStatus: 400,
+		},
+		{
+			name:           "empty cart",
+			request:        withGroups("Partner", `{"code":"ECO-1","cart":{"lines":[]}}`),
+			expectedStatus: 400,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			res, err := handleRequest(context.Background(), testCase.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != testCase.expectedStatus {
+				t.Errorf("Expected status %d, but got %d", testCase.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		expiresAt string
+		expected  bool
+	}{
+		{"2025-06-01T11:59:59Z", true},
+		{"2025-06-01T12:00:01Z", false},
+		{"2025-06-01", false}, // valid through the whole day
+		{"2025-05-31", true},
+		{"", false},
+		{"when stocks last", false},
+	}
+	for _, testCase := range testCases {
+		if got := expired(testCase.expiresAt, now); got != testCase.expected {
+			t.Errorf("expired(%q): expected %v, but got %v", testCase.expiresAt, testCase.expected, got)
+		}
+	}
+}
+
+func TestUnusable(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name           string
+		voucher        userVoucher
+		expectedStatus int // 0 means accepted
+		expectedReason string
+	}{
+		{"active", userVoucher{Status: "active", ExpiresAt: "2025-06-30"}, 0, ""},
+		{"used", userVoucher{Status: "used"}, 409, "Voucher has already been used"},
+		{"voided by a reversal", userVoucher{Status: "void"}, 409, "Voucher has been voided"},
+		{"expired by the job", userVoucher{Status: "expired"}, 410, "Voucher has expired"},
+		{"active past expiry", userVoucher{Status: "active", ExpiresAt: "2025-05-31"}, 410, "Voucher has expired"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			res, rejected := unusable(testCase.voucher, now)
+			if !rejected {
+				if testCase.expectedStatus != 0 {
+					t.Fatalf("Expected status %d, but the voucher was accepted", testCase.expectedStatus)
+				}
+				return
+			}
+			if res.StatusCode != testCase.expectedStatus || !strings.Contains(res.Body, testCase.expectedReason) {
+				t.Errorf("Expected %d %q, but got %d %s", testCase.expectedStatus, testCase.expectedReason, res.StatusCode, res.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/cursor"
+	"hello-world/internal/discount"
+	"hello-world/internal/ledger"
+	"hello-world/internal/tiers"
+	"hello-world/internal/wallet"
+)
+
+// The JSON shapes below mirror UserRewardProfile in src/types/rewards.ts.
+
+type HistoryEntry struct {
+	ID        string        `json:"id"`
+	UserID    string        `json:"userId"`
+	Type      string        `json:"type"` // donate | redeem | admin_adjust | expire | tier_change | reversal
+	Kg        amount.Grams  `json:"kg,omitempty"`
+	Points    amount.Points `json:"points"`
+	Note      string        `json:"note"`
+	Status    string        `json:"status,omitempty"`
+	CreatedAt string        `json:"createdAt"`
+}
+
+type ClaimedVoucher struct {
+	ID             string             `json:"id"`
+	Title          string             `json:"title"`
+	Code           string             `json:"code"`
+	Discount       string             `json:"discount"`
+	DiscountRule   *discount.Discount `json:"discountRule,omitempty"`
+	PointsRequired amount.Points      `json:"pointsRequired"`
+	ExpiresAt      string             `json:"expiresAt"`
+	Status         string             `json:"status"` // claimed | used | expired | void
+	UsedAt         string             `json:"usedAt,omitempty"`
+}
+
+type TierInfo struct {
+	Name              string       `json:"name"`
+	MultiplierPercent int64        `json:"multiplierPercent"`
+	Perks             []string     `json:"perks"`
+	NextTier          string       `json:"nextTier,omitempty"`
+	KgToNextTier      amount.Grams `json:"kgToNextTier,omitempty"`
+}
+
+type ProfileResponse struct {
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	Email           string           `json:"email"`
+	Points          amount.Points    `json:"points"`
+	PendingPoints   amount.Points    `json:"pendingPoints"`
+	TotalKg         amount.Grams     `json:"totalKg"`
+	Tier            TierInfo         `json:"tier"`
+	ExpiringPoints  amount.Points    `json:"expiringPoints"` // Points expiring before expiringBefore
+	ExpiringBefore  string           `json:"expiringBefore"`
+	NextExpiresAt   string           `json:"nextExpiresAt,omitempty"`
+	History         []HistoryEntry   `json:"history"`
+	NextCursor      string           `json:"nextCursor,omitempty"`
+	ClaimedVouchers []ClaimedVoucher `json:"claimedVouchers"`
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Config Load Failed")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "GET,OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization",
+	}
+
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: headers}, nil
+	}
+
+	// 1. Auth
+	claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{})
+	if !ok {
+		return events.APIGatewayProxyResponse{StatusCode: 401, Body: `{"message":"Unauthorized"}`, Headers: headers}, nil
+	}
+	userID, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name = email
+	}
+
+	// 2. Paging of history
+	limit := defaultPageSize
+	if v := request.QueryStringParameters["limit"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"limit must be a positive integer"}`, Headers: headers}, nil
+		}
+		limit = min(n, maxPageSize)
+	}
+	after, err := cursor.Decode(request.QueryStringParameters["cursor"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Invalid cursor"}`, Headers: headers}, nil
+	}
+
+	// 3. Ledger: balance and history come from the same read
+	entries, err := ledger.Load(ctx, dbClient, tableName, userID)
+	if err != nil {
+		fmt.Println("Ledger Error:", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching user data"}`, Headers: headers}, nil
+	}
+	balance := ledger.Summarize(entries)
+	expiry := ledger.UpcomingExpiry(ledger.Lots(entries), time.Now())
+
+	// SK prefixes differ per type, so order by time rather than by key
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].CreatedAt != entries[j].CreatedAt {
+			return entries[i].CreatedAt > entries[j].CreatedAt
+		}
+		return entries[i].SK > entries[j].SK
+	})
+	page, next, ok := pageAfter(entries, after, limit)
+	if !ok {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Invalid cursor"}`, Headers: headers}, nil
+	}
+
+	history := make([]HistoryEntry, 0, len(page))
+	for _, e := range page {
+		history = append(history, toHistoryEntry(userID, e))
+	}
+
+	// 4. Tier, placed by the kg recorded on PROFILE
+	profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
+	if err != nil {
+		fmt.Println("Profile Error:", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching user data"}`, Headers: headers}, nil
+	}
+	tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching tiers"}`, Headers: headers}, nil
+	}
+	tier := tiers.Current(tierConfig, profile)
+	tierInfo := TierInfo{Name: tier.Name, MultiplierPercent: tier.MultiplierPercent, Perks: tier.Perks}
+	if next, ok := tiers.Next(tierConfig, tier); ok {
+		tierInfo.NextTier = next.Name
+		tierInfo.KgToNextTier = max(next.MinKg-profile.TotalKg, 0)
+	}
+
+	// 5. Claimed vouchers
+	vouchers, err := loadClaimedVouchers(ctx, userID)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching vouchers"}`, Headers: headers}, nil
+	}
+
+	resp := ProfileResponse{
+		ID:              userID,
+		Name:            name,
+		Email:           email,
+		Points:          balance.Points,
+		PendingPoints:   balance.PendingPoints,
+		TotalKg:         balance.Kg,
+		Tier:            tierInfo,
+		ExpiringPoints:  expiry.ExpiringPoints,
+		ExpiringBefore:  expiry.ExpiringBefore,
+		NextExpiresAt:   expiry.NextExpiresAt,
+		History:         history,
+		NextCursor:      next,
+		ClaimedVouchers: vouchers,
+	}
+	body, _ := json.Marshal(resp)
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: headers}, nil
+}
+
+// pageAfter returns up to limit entries following the entry named by the
+// cursor, and the cursor for the page after that. It reports false when the
+// cursor names no entry, so a stale cursor is not read as the first page.
+func pageAfter(entries []ledger.Entry, after map[string]types.AttributeValue, limit int) ([]ledger.Entry, string, bool) {
+	start := 0
+	if after != nil {
+		sk, ok := after["SK"].(*types.AttributeValueMemberS)
+		if !ok {
+			return nil, "", false
+		}
+		start = slices.IndexFunc(entries, func(e ledger.Entry) bool { return e.SK == sk.Value }) + 1
+		if start == 0 {
+			return nil, "", false
+		}
+	}
+	end := min(start+limit, len(entries))
+	page := entries[start:end]
+	if end == len(entries) || len(page) == 0 {
+		return page, "", true
+	}
+	next, _ := cursor.Encode(map[string]types.AttributeValue{
+		"SK": &types.AttributeValueMemberS{Value: page[len(page)-1].SK},
+	})
+	return page, next, true
+}
+
+func toHistoryEntry(userID string, e ledger.Entry) HistoryEntry {
+	h := HistoryEntry{
+		ID:        e.SK,
+		UserID:    userID,
+		Kg:        e.AmountKg,
+		Points:    e.Delta(),
+		Note:      e.Note,
+		Status:    e.Status,
+		CreatedAt: e.CreatedAt,
+	}
+	switch e.Type {
+	case ledger.TypeDonate:
+		h.Type = "donate"
+	case ledger.TypeRedeem:
+		h.Type = "redeem"
+	case ledger.TypeExpire:
+		h.Type = "expire"
+	case ledger.TypeTierChange:
+		h.Type = "tier_change"
+	case ledger.TypeReversal:
+		h.Type = "reversal"
+	default:
+		h.Type = "admin_adjust"
+	}
+	return h
+}
+
+func loadClaimedVouchers(ctx context.Context, userID string) ([]ClaimedVoucher, error) {
+	p := dynamodb.NewQueryPaginator(dbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :v)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+			":v":  &types.AttributeValueMemberS{Value: wallet.SKPrefix},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+
+	now := time.Now()
+	vouchers := []ClaimedVoucher{}
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			w, err := wallet.FromItem(item)
+			if err != nil {
+				return nil, err
+			}
+			w.Refresh(now)
+			v := ClaimedVoucher{
+				ID:             w.ID,
+				Title:          w.Title,
+				Code:           w.Code,
+				Discount:       w.Discount,
+				DiscountRule:   w.DiscountRule,
+				PointsRequired: w.PointsRequired,
+				ExpiresAt:      w.ExpiresAt,
+				Status:         w.Status,
+				UsedAt:         w.UsedAt,
+			}
+			// The frontend calls a voucher held by the user "claimed"
+			if v.Status == wallet.StatusActive {
+				v.Status = "claimed"
+			}
+			vouchers = append(vouchers, v)
+		}
+	}
+	return vouchers, nil
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
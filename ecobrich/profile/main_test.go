@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/ledger"
+)
+
+func TestPageAfter(t *testing.T) {
+	entries := []ledger.Entry{{SK: "TRANS#3"}, {SK: "REDEEM#2"}, {SK: "TRANS#1"}}
+	after := func(sk string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{"SK": &types.AttributeValueMemberS{Value: sk}}
+	}
+	testCases := []struct {
+		name     string
+		after    map[string]types.AttributeValue
+		first    string
+		size     int
+		hasNext  bool
+		expectOK bool
+	}{
+		{"first page", nil, "TRANS#3", 2, true, true},
+		{"after cursor", after("REDEEM#2"), "TRANS#1", 1, false, true},
+		{"after last entry", after("TRANS#1"), "", 0, false, true},
+		{"unknown entry", after("TRANS#9"), "", 0, false, false},
+		{"cursor without SK", map[string]types.AttributeValue{}, "", 0, false, false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			page, next, ok := pageAfter(entries, testCase.after, 2)
+			if ok != testCase.expectOK {
+				t.Fatalf("Expected ok %v, but got %v", testCase.expectOK, ok)
+			}
+			if len(page) != testCase.size {
+				t.Fatalf("Expected %d entries, but got %d", testCase.size, len(page))
+			}
+			if testCase.size > 0 && page[0].SK != testCase.first {
+				t.Errorf("Expected page to start at %s, but got %s", testCase.first, page[0].SK)
+			}
+			if (next != "") != testCase.hasNext {
+				t.Errorf("Expected next cursor %v, but got %q", testCase.hasNext, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/dberr"
+	"hello-world/internal/ledger"
+	"hello-world/internal/tiers"
+)
+
+// ReconcileEvent is the input of both the daily schedule and manual runs.
+type ReconcileEvent struct {
+	Repair  bool     `json:"repair"`             // Overwrite PROFILE with the ledger totals
+	UserIDs []string `json:"user_ids,omitempty"` // Limit the run to these users, default is everyone
+	// Move users whose stored tier no longer matches their lifetime kg, for
+	// example after an admin changed the thresholds
+	SyncTiers bool `json:"sync_tiers"`
+}
+
+type UserDrift struct {
+	UserID   string         `json:"user_id"`
+	Stored   ledger.Profile `json:"stored"`
+	Ledger   ledger.Balance `json:"ledger"`
+	Drift    ledger.Drift   `json:"drift"`
+	Repaired bool           `json:"repaired"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type TierChange struct {
+	UserID string `json:"user_id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	UsersChecked int          `json:"users_checked"`
+	Drifted      []UserDrift  `json:"drifted"`
+	Repaired     int          `json:"repaired"`
+	TierChanges  []TierChange `json:"tier_changes"`
+}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+func handleRequest(ctx context.Context, event ReconcileEvent) (Report, error) {
+	userIDs := event.UserIDs
+	if len(userIDs) == 0 {
+		var err error
+		if userIDs, err = listUsers(ctx); err != nil {
+			return Report{}, err
+		}
+	}
+
+	var tierConfig []tiers.Tier
+	if event.SyncTiers {
+		var err error
+		if tierConfig, err = tiers.Load(ctx, dbClient, tableName); err != nil {
+			return Report{}, err
+		}
+	}
+
+	// A user whose partition cannot be read or written is reported with the
+	// error, and the run carries on with everyone after them
+	report := Report{Drifted: []UserDrift{}, TierChanges: []TierChange{}}
+	for _, userID := range userIDs {
+		if event.SyncTiers {
+			c, changed, err := syncTier(ctx, userID, tierConfig)
+			if err != nil {
+				fmt.Println("Tier Sync Error:", userID, err)
+				c, changed = TierChange{UserID: userID, Error: err.Error()}, true
+			}
+			if changed {
+				fmt.Printf("Tier for %s: %s -> %s %s\n", userID, c.From, c.To, c.Error)
+				report.TierChanges = append(report.TierChanges, c)
+			}
+		}
+
+		d, drifted, err := checkUser(ctx, userID, event.Repair)
+		if err != nil {
+			fmt.Println("Reconcile Error:", userID, err)
+			d, drifted = UserDrift{UserID: userID, Error: err.Error()}, true
+		}
+		report.UsersChecked++
+		if !drifted {
+			continue
+		}
+		fmt.Printf("Drift for %s: %+v\n", userID, d.Drift)
+		if d.Repaired {
+			report.Repaired++
+		}
+		report.Drifted = append(report.Drifted, d)
+	}
+	return report, nil
+}
+
+func checkUser(ctx context.Context, userID string, repair bool) (UserDrift, bool, error) {
+	// Profile first: its version tells us whether the ledger moved under us
+	profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
+	if err != nil {
+		return UserDrift{}, false, err
+	}
+	balance, err := ledger.LoadBalance(ctx, dbClient, tableName, userID)
+	if err != nil {
+		return UserDrift{}, false, err
+	}
+
+	drift, drifted := ledger.Compare(profile, balance)
+	d := UserDrift{UserID: userID, Stored: profile, Ledger: balance, Drift: drift}
+	if !drifted || !repair {
+		return d, drifted, nil
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	_, err = dbClient.UpdateItem(ctx, ledger.ProfileReset(tableName, userID, balance, profile.BalanceVersion, now))
+	if dberr.ConditionFailed(err) {
+		// A ledger write landed after we read; the next run will pick it up
+		d.Error = "ledger changed during reconciliation, skipped"
+		return d, true, nil
+	}
+	if err != nil {
+		return UserDrift{}, false, err
+	}
+	d.Repaired = true
+	return d, true, nil
+}
+
+// syncTier stores the tier the ledger's lifetime kg earns and records the
+// promotion or demotion in the user's history.
+func syncTier(ctx context.Context, userID string, tierConfig []tiers.Tier) (TierChange, bool, error) {
+	profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
+	if err != nil || !profile.Exists {
+		// Users without a PROFILE are left to the drift repair
+		return TierChange{}, false, err
+	}
+	balance, err := ledger.LoadBalance(ctx, dbClient, tableName, userID)
+	if err != nil {
+		return TierChange{}, false, err
+	}
+
+	to, change := tiers.Evaluate(tierConfig, profile.Tier, balance.Kg)
+	if profile.Tier != "" && change == nil {
+		return TierChange{}, false, nil
+	}
+	c := TierChange{UserID: userID, From: profile.Tier, To: to.Name}
+
+	now := time.Now()
+	timestamp := now.Format(time.RFC3339)
+	items := []types.TransactWriteItem{
+		ledger.ProfileUpdate(tableName, userID, ledger.Delta{Tier: to.Name}, timestamp, &profile.BalanceVersion),
+	}
+	if change != nil {
+		items = append(items, change.HistoryItem(tableName, userID, balance.Kg, now))
+	}
+	_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if dberr.ConditionFailed(err) {
+		c.Error = "ledger changed during tier sync, skipped"
+		return c, true, nil
+	}
+	if err != nil {
+		return TierChange{}, false, err
+	}
+	return c, true, nil
+}
+
+// listUsers finds every user partition, including users whose history was
+// written before PROFILE items were kept up to date.
+func listUsers(ctx context.Context) ([]string, error) {
+	p := dynamodb.NewScanPaginator(dbClient, &dynamodb.ScanInput{
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String("PK"),
+		FilterExpression:     aws.String("begins_with(PK, :u)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: ledger.UserPK("")},
+		},
+	})
+
+	seen := map[string]bool{}
+	var users []string
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			pk, ok := item["PK"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			userID := strings.TrimPrefix(pk.Value, ledger.UserPK(""))
+			if !seen[userID] {
+				seen[userID] = true
+				users = append(users, userID)
+			}
+		}
+	}
+	return users, nil
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"os"
 	"strconv"
 	"time"
@@ -14,12 +15,39 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/codes"
+	"hello-world/internal/dberr"
+	"hello-world/internal/eligibility"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ids"
+	"hello-world/internal/ledger"
+	"hello-world/internal/limits"
+	"hello-world/internal/tiers"
+	"hello-world/internal/validity"
+	"hello-world/internal/wallet"
 )
 
 type RedeemRequest struct {
 	VoucherID string `json:"voucher_id"` // format: DEF#... or just ID part
 }
 
+type RedeemResponse struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// LimitResponse is returned with 429 when a redemption limit is reached.
+type LimitResponse struct {
+	Message  string       `json:"message"`
+	Limit    limits.Scope `json:"limit"`
+	ResetsAt string       `json:"resets_at,omitempty"` // Absent for the lifetime limit
+}
+
+// maxClaimAttempts bounds how many pooled codes one redemption tries.
+const maxClaimAttempts = 3
+
 var dbClient *dynamodb.Client
 var tableName string
 
@@ -36,7 +64,7 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 	headers := map[string]string{
 		"Access-Control-Allow-Origin":  "*",
 		"Access-Control-Allow-Methods": "POST,OPTIONS",
-		"Access-Control-Allow-Headers": "Content-Type,Authorization",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
 	}
 
 	if request.HTTPMethod == "OPTIONS" {
@@ -76,15 +104,17 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 		return events.APIGatewayProxyResponse{StatusCode: 404, Body: `{"message":"Voucher not found"}`, Headers: headers}, nil
 	}
 
-	pointsReqFunc := func() float64 {
-		if val, ok := vRes.Item["PointsRequired"].(*types.AttributeValueMemberN); ok {
-			f, _ := strconv.ParseFloat(val.Value, 64)
-			return f
-		}
-		return 0
+	// Archived definitions stay readable for history but cannot be redeemed
+	if val, ok := vRes.Item["Status"].(*types.AttributeValueMemberS); ok && val.Value != "active" {
+		return events.APIGatewayProxyResponse{StatusCode: 410, Body: `{"message":"Voucher is no longer available"}`, Headers: headers}, nil
+	}
+
+	pointCost, err := amount.PointsAttr(vRes.Item, "PointsRequired")
+	if err != nil {
+		fmt.Println("Voucher Error:", voucherSK, err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Voucher has an invalid point cost"}`, Headers: headers}, nil
 	}
-	pointCost := pointsReqFunc()
-	
+
 	// Get Voucher Code/Title to copy
 	voucherCode := ""
 	if val, ok := vRes.Item["Code"].(*types.AttributeValueMemberS); ok {
@@ -95,77 +125,131 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 		voucherTitle = val.Value
 	}
 	voucherDiscount := ""
-    if val, ok := vRes.Item["Discount"].(*types.AttributeValueMemberS); ok {
-        voucherDiscount = val.Value
-    }
-    voucherExpires := ""
-    if val, ok := vRes.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
-        voucherExpires = val.Value
-    }
+	if val, ok := vRes.Item["Discount"].(*types.AttributeValueMemberS); ok {
+		voucherDiscount = val.Value
+	}
+	voucherExpires := ""
+	if val, ok := vRes.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		voucherExpires = val.Value
+	}
+	// The rule is copied like the title, so later edits of the definition
+	// don't change what the member holds
+	voucherRule, hasRule := vRes.Item["DiscountRule"]
+	voucherStarts := ""
+	if val, ok := vRes.Item["StartsAt"].(*types.AttributeValueMemberS); ok {
+		voucherStarts = val.Value
+	}
+	switch validity.FromStrings(voucherStarts, voucherExpires).At(time.Now()) {
+	case validity.Scheduled:
+		return events.APIGatewayProxyResponse{StatusCode: 409, Body: `{"message":"Voucher is not available yet"}`, Headers: headers}, nil
+	case validity.Expired:
+		return events.APIGatewayProxyResponse{StatusCode: 410, Body: `{"message":"Voucher has expired"}`, Headers: headers}, nil
+	}
+	voucherMinTier := ""
+	if val, ok := vRes.Item["MinTier"].(*types.AttributeValueMemberS); ok {
+		voucherMinTier = val.Value
+	}
+	voucherLimits, err := limits.FromItem(vRes.Item, "Limits")
+	if err != nil {
+		fmt.Println("Voucher Error:", voucherSK, err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Voucher has invalid limits"}`, Headers: headers}, nil
+	}
+	voucherEligibility, err := eligibility.FromItem(vRes.Item, "Eligibility")
+	if err != nil {
+		fmt.Println("Voucher Error:", voucherSK, err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Voucher has invalid eligibility"}`, Headers: headers}, nil
+	}
+	pooled := false
+	if val, ok := vRes.Item["CodePool"].(*types.AttributeValueMemberBOOL); ok {
+		pooled = val.Value
+	}
+	// Limited vouchers carry Remaining; the transaction below decrements it
+	remaining, limited := vRes.Item["Remaining"].(*types.AttributeValueMemberN)
+	if limited {
+		if n, err := strconv.ParseInt(remaining.Value, 10, 64); err == nil && n <= 0 {
+			return events.APIGatewayProxyResponse{StatusCode: 409, Body: `{"message":"Voucher is sold out"}`, Headers: headers}, nil
+		}
+	}
 
 	// 4. Calculate User Points
-	// Query Get All Transactions for User
-	qOut, err := dbClient.Query(ctx, &dynamodb.QueryInput{
-		TableName: aws.String(tableName),
-		KeyConditionExpression: aws.String("PK = :pk"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: "USER#" + userID},
-		},
-	})
+	// The version must be read before the ledger: if another redemption
+	// commits in between, the guard below sees a newer version and cancels.
+	profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
 	if err != nil {
+		fmt.Println("Profile Error:", err)
 		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching user data"}`, Headers: headers}, nil
 	}
+	version := profile.BalanceVersion
 
-	totalPoints := 0.0
-	for _, item := range qOut.Items {
-		// History items (Donate, AdminAdjust) OR Redeem items
-		// Donate: PointsEarned > 0, Status=approved
-		// AdminAdjust: PointsEarned (can be pos/neg), Status=approved
-		// Redeem: PointsSpent > 0 (or PointsEarned negative?)
-
-		status := ""
-		if val, ok := item["Status"].(*types.AttributeValueMemberS); ok {
-			status = val.Value
+	// Vouchers can be reserved for a tier and above
+	if voucherMinTier != "" {
+		tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+		if err != nil {
+			fmt.Println("Tiers Error:", err)
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching user data"}`, Headers: headers}, nil
 		}
-
-		// Only count approved
-		if status == "approved" || status == "" { // Some records might not have status if implicit? No, all have.
-			// Legacy/Donate items
-			if pVal, ok := item["PointsEarned"].(*types.AttributeValueMemberN); ok {
-				p, _ := strconv.ParseFloat(pVal.Value, 64)
-				totalPoints += p
-			}
-			// Redeem items might store cost in PointsSpent
-			if pVal, ok := item["PointsSpent"].(*types.AttributeValueMemberN); ok {
-				p, _ := strconv.ParseFloat(pVal.Value, 64)
-				totalPoints -= p
-			}
+		if !tiers.Allows(tierConfig, tiers.Current(tierConfig, profile).Name, voucherMinTier) {
+			return events.APIGatewayProxyResponse{StatusCode: 403, Body: fmt.Sprintf(`{"message":"This voucher is reserved for %s members and above"}`, voucherMinTier), Headers: headers}, nil
 		}
 	}
+	entries, err := ledger.Load(ctx, dbClient, tableName, userID)
+	if err != nil {
+		fmt.Println("Ledger Error:", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching user data"}`, Headers: headers}, nil
+	}
+
+	// Restricted vouchers explain why the member cannot have them
+	balance := ledger.Summarize(entries)
+	member := eligibility.Member{SignedIn: true, TotalKg: balance.Kg, Segments: profile.Segments, Donations: balance.Donations}
+	if reason := voucherEligibility.Check(member); reason != "" {
+		return events.APIGatewayProxyResponse{StatusCode: 403, Body: fmt.Sprintf(`{"message":%q}`, reason), Headers: headers}, nil
+	}
 
-	if totalPoints < pointCost {
+	// Spend the lots expiring first; expired lots no longer count even if
+	// the expiry job has not written them off yet
+	nowTime := time.Now()
+	allocations, ok := ledger.Allocate(ledger.Lots(entries), pointCost, nowTime)
+	if !ok {
 		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Not enough points"}`, Headers: headers}, nil
 	}
 
-	// 5. Transact Write: Redeem History + User Voucher
-	now := time.Now().Format(time.RFC3339)
-	redeemSK := "REDEEM#" + now
-	userVoucherSK := "VOUCHER#" + fmt.Sprintf("%d", time.Now().UnixNano())
+	// 5. Transact Write: Profile Balance (guarded) + Redeem History + User Voucher
+	now := nowTime.Format(time.RFC3339)
+	redeemSK := "REDEEM#" + ids.NewAt(nowTime)
+	userVoucherSK := wallet.SK(ids.NewAt(nowTime))
+
+	// Pooled vouchers give every redeemer a code of their own. A candidate
+	// can be claimed by a concurrent redemption, in which case the next one
+	// is tried.
+	candidates := []string{voucherCode}
+	if pooled {
+		if candidates, err = codes.Available(ctx, dbClient, tableName, voucherSK, maxClaimAttempts); err != nil {
+			fmt.Println("DynamoDB Error:", err)
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching voucher codes"}`, Headers: headers}, nil
+		}
+		if len(candidates) == 0 {
+			return events.APIGatewayProxyResponse{StatusCode: 409, Body: `{"message":"Voucher is sold out"}`, Headers: headers}, nil
+		}
+		rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	}
 
-	_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: []types.TransactWriteItem{
+	for _, code := range candidates {
+		items := []types.TransactWriteItem{
+			ledger.ProfileUpdate(tableName, userID, ledger.Delta{Points: -pointCost}, now, &version),
 			{
 				Put: &types.Put{
 					TableName: aws.String(tableName),
 					Item: map[string]types.AttributeValue{
-						"PK": &types.AttributeValueMemberS{Value: "USER#" + userID},
-						"SK": &types.AttributeValueMemberS{Value: redeemSK},
-						"Type": &types.AttributeValueMemberS{Value: "REDEEM"},
-						"PointsSpent": &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", pointCost)},
-						"VoucherRef": &types.AttributeValueMemberS{Value: voucherSK},
-						"Status": &types.AttributeValueMemberS{Value: "approved"},
-						"CreatedAt": &types.AttributeValueMemberS{Value: now},
-						"Note": &types.AttributeValueMemberS{Value: "Đổi voucher: " + voucherTitle},
+						"PK":            &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+						"SK":            &types.AttributeValueMemberS{Value: redeemSK},
+						"Type":          &types.AttributeValueMemberS{Value: string(ledger.TypeRedeem)},
+						"PointsSpent":   pointCost.Attr(),
+						"VoucherRef":    &types.AttributeValueMemberS{Value: voucherSK},
+						"UserVoucherSK": &types.AttributeValueMemberS{Value: userVoucherSK}, // For reversals
+						"Lots":          ledger.AllocationsAttr(allocations),
+						"Status":        &types.AttributeValueMemberS{Value: ledger.StatusApproved},
+						"CreatedAt":     &types.AttributeValueMemberS{Value: now},
+						"Note":          &types.AttributeValueMemberS{Value: "Đổi voucher: " + voucherTitle},
 					},
 				},
 			},
@@ -173,28 +257,90 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 				Put: &types.Put{
 					TableName: aws.String(tableName),
 					Item: map[string]types.AttributeValue{
-						"PK": &types.AttributeValueMemberS{Value: "USER#" + userID},
-						"SK": &types.AttributeValueMemberS{Value: userVoucherSK},
-						"Type": &types.AttributeValueMemberS{Value: "USER_VOUCHER"},
-						"Code": &types.AttributeValueMemberS{Value: voucherCode},
-						"Title": &types.AttributeValueMemberS{Value: voucherTitle},
-						"Discount": &types.AttributeValueMemberS{Value: voucherDiscount},
-						"ExpiresAt": &types.AttributeValueMemberS{Value: voucherExpires},
-						"Status": &types.AttributeValueMemberS{Value: "active"},
-						"CreatedAt": &types.AttributeValueMemberS{Value: now},
+						"PK":             &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+						"SK":             &types.AttributeValueMemberS{Value: userVoucherSK},
+						"Type":           &types.AttributeValueMemberS{Value: wallet.Type},
+						"Code":           &types.AttributeValueMemberS{Value: code},
+						"Title":          &types.AttributeValueMemberS{Value: voucherTitle},
+						"Discount":       &types.AttributeValueMemberS{Value: voucherDiscount},
+						"ExpiresAt":      &types.AttributeValueMemberS{Value: voucherExpires},
+						"PointsRequired": pointCost.Attr(),
+						"VoucherRef":     &types.AttributeValueMemberS{Value: voucherSK},
+						"Status":         &types.AttributeValueMemberS{Value: wallet.StatusActive},
+						"CreatedAt":      &types.AttributeValueMemberS{Value: now},
 					},
 				},
 			},
-		},
-	})
+		}
+		if hasRule {
+			items[2].Put.Item["DiscountRule"] = voucherRule // the USER_VOUCHER
+		}
+		if limited {
+			items = append(items, types.TransactWriteItem{
+				Update: &types.Update{
+					TableName: aws.String(tableName),
+					Key: map[string]types.AttributeValue{
+						"PK": &types.AttributeValueMemberS{Value: "VOUCHER"},
+						"SK": &types.AttributeValueMemberS{Value: voucherSK},
+					},
+					UpdateExpression:    aws.String("SET Remaining = Remaining - :one"),
+					ConditionExpression: aws.String("Remaining > :zero"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":one":  &types.AttributeValueMemberN{Value: "1"},
+						":zero": &types.AttributeValueMemberN{Value: "0"},
+					},
+				},
+			})
+		}
+		claimIndex := len(items)
+		if pooled {
+			items = append(items, codes.ClaimItem(tableName, voucherSK, code, userID, userVoucherSK, now))
+		}
+		// Each limit is a counter that refuses to go past its maximum
+		counters := voucherLimits.Counters(voucherSK, userID, nowTime)
+		countersIndex := len(items)
+		for _, c := range counters {
+			items = append(items, c.Increment(tableName))
+		}
 
-	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"Transaction Error: %v"}`, err), Headers: headers}, nil
+		_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+
+		if dberr.ConditionFailedAt(err, 0) {
+			// Another redemption spent points after the balance was read
+			return events.APIGatewayProxyResponse{StatusCode: 409, Body: `{"message":"Balance changed, please try again"}`, Headers: headers}, nil
+		}
+		if limited && dberr.ConditionFailedAt(err, 3) {
+			// The last unit went to someone else after the voucher was read
+			return events.APIGatewayProxyResponse{StatusCode: 409, Body: `{"message":"Voucher is sold out"}`, Headers: headers}, nil
+		}
+		for i, c := range counters {
+			if dberr.ConditionFailedAt(err, countersIndex+i) {
+				return limitReached(headers, c), nil
+			}
+		}
+		if pooled && dberr.ConditionFailedAt(err, claimIndex) {
+			continue
+		}
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"Transaction Error: %v"}`, err), Headers: headers}, nil
+		}
+
+		body, _ := json.Marshal(RedeemResponse{Message: "Redeem Success", Code: code})
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: headers}, nil
 	}
+	return events.APIGatewayProxyResponse{StatusCode: 409, Body: `{"message":"Voucher codes are in high demand, please try again"}`, Headers: headers}, nil
+}
 
-	return events.APIGatewayProxyResponse{StatusCode: 200, Body: `{"message":"Redeem Success"}`, Headers: headers}, nil
+func limitReached(headers map[string]string, c limits.Counter) events.APIGatewayProxyResponse {
+	res := LimitResponse{Message: c.Message(), Limit: c.Scope}
+	if !c.ResetsAt.IsZero() {
+		res.ResetsAt = c.ResetsAt.Format(time.RFC3339)
+		res.Message += ", try again after " + c.ResetsAt.Format("02/01/2006 15:04")
+	}
+	body, _ := json.Marshal(res)
+	return events.APIGatewayProxyResponse{StatusCode: 429, Body: string(body), Headers: headers}
 }
 
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
 }
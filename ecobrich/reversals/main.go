@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/authz"
+	"hello-world/internal/dberr"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ids"
+	"hello-world/internal/ledger"
+	"hello-world/internal/limits"
+	"hello-world/internal/wallet"
+)
+
+type ReverseRequest struct {
+	Reason string `json:"reason"` // Shown to the member on the voided voucher
+}
+
+type ReverseResponse struct {
+	Message        string        `json:"message"`
+	ReversalID     string        `json:"reversal_id"`
+	PointsRefunded amount.Points `json:"points_refunded"`
+	Restocked      bool          `json:"restocked"` // Pooled codes are retired with the voucher
+}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+// handleRequest serves POST /admin/redemptions/{userId}/{sk}/reverse. In one
+// transaction it marks the REDEEM reversed, voids its voucher, writes a
+// REVERSAL refunding the points to the lots they came from, puts the unit
+// back into a limited definition (not a pooled one, see restockItem) and
+// releases the redemption limits still running. A voucher already used at a
+// partner cannot be reversed.
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+	caller, err := authz.Authorize(request, authz.PermReverseRedeems)
+	if err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
+	}
+
+	userID := request.PathParameters["userId"]
+	sk, err := url.PathUnescape(request.PathParameters["sk"])
+	if err != nil || userID == "" || sk == "" {
+		return response(400, "Invalid redemption reference"), nil
+	}
+	// The history API returns the SK as is, but accept the bare ID too
+	if !strings.HasPrefix(sk, "REDEEM#") {
+		sk = "REDEEM#" + sk
+	}
+	var body ReverseRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return response(400, "Invalid request body"), nil
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" {
+		return response(400, "A reversal reason is required"), nil
+	}
+
+	// 1. The redemption and the voucher it handed out
+	redeemItem, err := ledger.GetItem(ctx, dbClient, tableName, userID, sk)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load redemption"), nil
+	}
+	redeem, ok, err := ledger.FromItem(redeemItem)
+	if err != nil {
+		fmt.Println("Ledger Error:", err)
+		return response(500, "System Error: Invalid redemption record"), nil
+	}
+	if !ok || redeem.Type != ledger.TypeRedeem {
+		return response(404, "Redemption not found"), nil
+	}
+	if _, reversed := redeemItem["ReversedAt"]; reversed {
+		return response(409, "Redemption has already been reversed"), nil
+	}
+	voucherRef := stringAttr(redeemItem, "VoucherRef")
+
+	uvItem, err := findUserVoucher(ctx, userID, redeemItem)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load voucher"), nil
+	}
+	if uvItem == nil {
+		return response(409, "The voucher of this redemption could not be found"), nil
+	}
+	uv, err := wallet.FromItem(uvItem)
+	if err != nil {
+		fmt.Println("Wallet Error:", err)
+		return response(500, "System Error: Invalid voucher record"), nil
+	}
+	switch uv.Status {
+	case wallet.StatusUsed:
+		return response(409, "Voucher has already been used at a partner"), nil
+	case wallet.StatusVoid:
+		return response(409, "Redemption has already been reversed"), nil
+	}
+
+	// 2. What the definition needs back
+	defRes, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "VOUCHER"},
+			"SK": &types.AttributeValueMemberS{Value: voucherRef},
+		},
+	})
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load voucher definition"), nil
+	}
+	restock, restocked := restockItem(voucherRef, defRes.Item)
+	voucherLimits, err := limits.FromItem(defRes.Item, "Limits")
+	if err != nil {
+		fmt.Println("Voucher Error:", voucherRef, err)
+		return response(500, "System Error: Voucher has invalid limits"), nil
+	}
+	now := time.Now()
+	counters, err := runningCounters(ctx, voucherLimits.Counters(voucherRef, userID, redeemedAt(redeem)), now)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load redemption limits"), nil
+	}
+
+	// 3. Transact Write: REDEEM (guarded) + USER_VOUCHER (guarded) + REVERSAL + Profile + restock + limits
+	timestamp := now.Format(time.RFC3339)
+	reversalSK := "REVERSAL#" + ids.NewAt(now)
+	points := redeem.PointsSpent
+
+	reversal := map[string]types.AttributeValue{
+		"PK":           &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+		"SK":           &types.AttributeValueMemberS{Value: reversalSK},
+		"Type":         &types.AttributeValueMemberS{Value: string(ledger.TypeReversal)},
+		"PointsEarned": points.Attr(),
+		"ReversalOf":   &types.AttributeValueMemberS{Value: redeem.SK},
+		"VoucherRef":   &types.AttributeValueMemberS{Value: voucherRef},
+		"Status":       &types.AttributeValueMemberS{Value: ledger.StatusApproved},
+		"CreatedAt":    &types.AttributeValueMemberS{Value: timestamp},
+		"ExpiresAt":    &types.AttributeValueMemberS{Value: ledger.LotExpiry(now).Format(time.RFC3339)}, // For points whose lot expired
+		"Note":         &types.AttributeValueMemberS{Value: "Hoàn điểm voucher: " + uv.Title},
+		"Reason":       &types.AttributeValueMemberS{Value: reason},
+		"AdminID":      &types.AttributeValueMemberS{Value: caller.UserID}, // Audit trail
+	}
+	if len(redeem.Allocations) > 0 {
+		// The points go back to the lots they were spent from
+		reversal["Lots"] = ledger.AllocationsAttr(redeem.Allocations)
+	}
+
+	items := []types.TransactWriteItem{
+		{
+			Update: &types.Update{
+				TableName: aws.String(tableName),
+				Key: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+					"SK": &types.AttributeValueMemberS{Value: redeem.SK},
+				},
+				UpdateExpression:    aws.String("SET ReversedAt = :t, ReversedBy = :admin, ReversalSK = :rev"),
+				ConditionExpression: aws.String("attribute_exists(PK) AND attribute_not_exists(ReversedAt)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":t":     &types.AttributeValueMemberS{Value: timestamp},
+					":admin": &types.AttributeValueMemberS{Value: caller.UserID},
+					":rev":   &types.AttributeValueMemberS{Value: reversalSK},
+				},
+			},
+		},
+		{
+			// A partner may consume the voucher while this runs
+			Update: &types.Update{
+				TableName: aws.String(tableName),
+				Key: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+					"SK": &types.AttributeValueMemberS{Value: wallet.SK(uv.ID)},
+				},
+				UpdateExpression:         aws.String("SET #status = :void, VoidedAt = :t, VoidedBy = :admin, VoidReason = :reason"),
+				ConditionExpression:      aws.String("#status IN (:active, :expired)"),
+				ExpressionAttributeNames: map[string]string{"#status": "Status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":void":    &types.AttributeValueMemberS{Value: wallet.StatusVoid},
+					":active":  &types.AttributeValueMemberS{Value: wallet.StatusActive},
+					":expired": &types.AttributeValueMemberS{Value: wallet.StatusExpired},
+					":t":       &types.AttributeValueMemberS{Value: timestamp},
+					":admin":   &types.AttributeValueMemberS{Value: caller.UserID},
+					":reason":  &types.AttributeValueMemberS{Value: reason},
+				},
+			},
+		},
+		{
+			Put: &types.Put{
+				TableName: aws.String(tableName),
+				Item:      reversal,
+			},
+		},
+		ledger.ProfileUpdate(tableName, userID, ledger.Delta{Points: points}, timestamp, nil),
+	}
+	if restocked {
+		items = append(items, restock)
+	}
+	for _, c := range counters {
+		items = append(items, c.Decrement(tableName))
+	}
+
+	_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+
+	if dberr.ConditionFailedAt(err, 0) {
+		return response(409, "Redemption has already been reversed"), nil
+	}
+	if dberr.ConditionFailedAt(err, 1) {
+		return response(409, "Voucher has already been used at a partner"), nil
+	}
+	if dberr.ConditionFailed(err) {
+		// A counter reset or the definition changed after it was read
+		return response(409, "Voucher changed, please try again"), nil
+	}
+	if err != nil {
+		fmt.Println("DynamoDB Transaction Error:", err)
+		return response(500, "System Error: Failed to reverse redemption"), nil
+	}
+
+	return jsonResponse(200, ReverseResponse{
+		Message:        "Redemption reversed",
+		ReversalID:     reversalSK,
+		PointsRefunded: points,
+		Restocked:      restocked,
+	}), nil
+}
+
+// findUserVoucher returns the USER_VOUCHER a redemption wrote, or nil.
+// Redemptions before UserVoucherSK was recorded are matched on the voucher
+// definition and the timestamp both items were written with.
+func findUserVoucher(ctx context.Context, userID string, redeemItem map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	if sk := stringAttr(redeemItem, "UserVoucherSK"); sk != "" {
+		out, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+				"SK": &types.AttributeValueMemberS{Value: sk},
+			},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.Item, nil
+	}
+
+	p := dynamodb.NewQueryPaginator(dbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :v)"),
+		FilterExpression:       aws.String("VoucherRef = :ref AND CreatedAt = :t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":  &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+			":v":   &types.AttributeValueMemberS{Value: wallet.SKPrefix},
+			":ref": &types.AttributeValueMemberS{Value: stringAttr(redeemItem, "VoucherRef")},
+			":t":   &types.AttributeValueMemberS{Value: stringAttr(redeemItem, "CreatedAt")},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(out.Items) > 0 {
+			return out.Items[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// runningCounters keeps the counters the redemption still weighs on: those
+// that exist and whose period has not ended. Finished periods are left as
+// they are, their counters expire through the TTL.
+func runningCounters(ctx context.Context, counters []limits.Counter, now time.Time) ([]limits.Counter, error) {
+	var running []limits.Counter
+	for _, c := range counters {
+		if !c.ResetsAt.IsZero() && !now.Before(c.ResetsAt) {
+			continue
+		}
+		out, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"PK": &types.AttributeValueMemberS{Value: c.PK},
+				"SK": &types.AttributeValueMemberS{Value: c.SK},
+			},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Limits added to the definition after the redemption never counted it
+		if n, ok := out.Item["Redeemed"].(*types.AttributeValueMemberN); ok && n.Value != "0" {
+			running = append(running, c)
+		}
+	}
+	return running, nil
+}
+
+// restockItem puts the redeemed unit back into a definition with a limited
+// quantity. Pooled definitions are not restocked: Remaining counts their
+// unclaimed codes, and the member has already seen the code they claimed,
+// so it is retired with the voided voucher rather than handed out again.
+func restockItem(voucherRef string, def map[string]types.AttributeValue) (types.TransactWriteItem, bool) {
+	if _, limited := def["Remaining"].(*types.AttributeValueMemberN); !limited {
+		return types.TransactWriteItem{}, false
+	}
+	if pooled, ok := def["CodePool"].(*types.AttributeValueMemberBOOL); ok && pooled.Value {
+		return types.TransactWriteItem{}, false
+	}
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "VOUCHER"},
+			"SK": &types.AttributeValueMemberS{Value: voucherRef},
+		},
+		UpdateExpression: aws.String("SET Remaining = Remaining + :one"),
+		// A definition that got codes after it was read no longer counts units
+		ConditionExpression: aws.String("attribute_exists(Remaining) AND NOT CodePool = :true"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":  &types.AttributeValueMemberN{Value: "1"},
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	}}, true
+}
+
+// redeemedAt is when the redemption counted against its period limits.
+func redeemedAt(e ledger.Entry) time.Time {
+	if t, err := time.Parse(time.RFC3339, e.CreatedAt); err == nil {
+		return t
+	}
+	t, _ := ids.TimeOfSK(e.SK)
+	return t
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
+		"Access-Control-Allow-Methods": "POST,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
+}
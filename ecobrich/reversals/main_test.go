@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRestockItem(t *testing.T) {
+	testCases := []struct {
+		name     string
+		def      map[string]types.AttributeValue
+		expected bool
+	}{
+		{
+			name:     "unlimited definition",
+			def:      map[string]types.AttributeValue{},
+			expected: false,
+		},
+		{
+			name:     "limited definition",
+			def:      map[string]types.AttributeValue{"Remaining": &types.AttributeValueMemberN{Value: "4"}},
+			expected: true,
+		},
+		{
+			// The claimed code stays with the voided voucher, so there is no unit to give back
+			name: "pooled definition",
+			def: map[string]types.AttributeValue{
+				"Remaining": &types.AttributeValueMemberN{Value: "4"},
+				"CodePool":  &types.AttributeValueMemberBOOL{Value: true},
+			},
+			expected: false,
+		},
+		{
+			name:     "missing definition",
+			def:      nil,
+			expected: false,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			item, restocked := restockItem("DEF#01", testCase.def)
+			if restocked != testCase.expected {
+				t.Fatalf("Expected restocked %v, but got %v", testCase.expected, restocked)
+			}
+			if restocked && *item.Update.UpdateExpression != "SET Remaining = Remaining + :one" {
+				t.Errorf("Expected a Remaining increment, but got %q", *item.Update.UpdateExpression)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/authz"
+	"hello-world/internal/eligibility"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ledger"
+)
+
+// SegmentsRequest adds and removes segments, e.g. "school:nguyen-du", so
+// concurrent edits of different segments do not overwrite each other.
+type SegmentsRequest struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+type SegmentsResponse struct {
+	UserID   string   `json:"user_id"`
+	Segments []string `json:"segments"`
+}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+// handleRequest serves PUT /admin/users/{userId}/segments. Segments are kept
+// on PROFILE and matched by voucher eligibility rules.
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+	if request.HTTPMethod != "PUT" {
+		return response(405, "Method Not Allowed"), nil
+	}
+	if _, err := authz.Authorize(request, authz.PermManageConfig); err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
+	}
+
+	userID := request.PathParameters["userId"]
+	if userID == "" {
+		return response(400, "Missing userId"), nil
+	}
+	var body SegmentsRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return response(400, "Invalid request body"), nil
+	}
+	add, err := normalize(body.Add)
+	if err != nil {
+		return response(400, err.Error()), nil
+	}
+	remove, err := normalize(body.Remove)
+	if err != nil {
+		return response(400, err.Error()), nil
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return response(400, "Send add or remove"), nil
+	}
+
+	// DynamoDB refuses ADD and DELETE on the same attribute in one update
+	if len(add) > 0 {
+		if err := update(ctx, userID, "ADD", add); err != nil {
+			fmt.Println("DynamoDB Error:", err)
+			return response(500, "System Error: Failed to update segments"), nil
+		}
+	}
+	if len(remove) > 0 {
+		if err := update(ctx, userID, "DELETE", remove); err != nil {
+			fmt.Println("DynamoDB Error:", err)
+			return response(500, "System Error: Failed to update segments"), nil
+		}
+	}
+
+	profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load profile"), nil
+	}
+	segments := append([]string{}, profile.Segments...)
+	sort.Strings(segments)
+	return jsonResponse(200, SegmentsResponse{UserID: userID, Segments: segments}), nil
+}
+
+func update(ctx context.Context, userID, action string, segments []string) error {
+	_, err := dbClient.UpdateItem(ctx, updateInput(userID, action, segments))
+	return err
+}
+
+// updateInput applies action, ADD or DELETE, to the PROFILE segment set.
+// SEGMENTS is a reserved word, so the attribute goes through a name.
+func updateInput(userID, action string, segments []string) *dynamodb.UpdateItemInput {
+	return &dynamodb.UpdateItemInput{
+		TableName:                aws.String(tableName),
+		Key:                      ledger.ProfileKey(userID),
+		UpdateExpression:         aws.String(action + " #segments :s"),
+		ExpressionAttributeNames: map[string]string{"#segments": "Segments"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberSS{Value: segments},
+		},
+	}
+}
+
+func normalize(segments []string) ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range segments {
+		s = eligibility.NormalizeSegment(s)
+		if s == "" {
+			return nil, fmt.Errorf("segments must not be empty")
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
+		"Access-Control-Allow-Methods": "PUT,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
+}
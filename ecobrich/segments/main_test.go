@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestUpdateInput(t *testing.T) {
+	testCases := []struct {
+		name       string
+		action     string
+		expression string
+	}{
+		{"add", "ADD", "ADD #segments :s"},
+		{"remove", "DELETE", "DELETE #segments :s"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			input := updateInput("user-1", testCase.action, []string{"school:nguyen-du"})
+			if *input.UpdateExpression != testCase.expression {
+				t.Errorf("Expected %q, but got %q", testCase.expression, *input.UpdateExpression)
+			}
+			if input.ExpressionAttributeNames["#segments"] != "Segments" {
+				t.Errorf("Expected #segments to name Segments, but got %v", input.ExpressionAttributeNames)
+			}
+			if s, ok := input.ExpressionAttributeValues[":s"].(*types.AttributeValueMemberSS); !ok || len(s.Value) != 1 {
+				t.Errorf("Expected :s to be the segment set, but got %v", input.ExpressionAttributeValues[":s"])
+			}
+		})
+	}
+}
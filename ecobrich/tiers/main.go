@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/authz"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/tiers"
+)
+
+type TiersResponse struct {
+	Tiers []tiers.Tier `json:"tiers"`
+}
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+
+	switch request.HTTPMethod {
+	case "GET":
+		// Every member can see the ladder and its perks
+		if _, err := authz.FromRequest(request); err != nil {
+			return response(authz.StatusCode(err), err.Error()), nil
+		}
+		return listTiers(ctx)
+	case "PUT":
+		if _, err := authz.Authorize(request, authz.PermManageConfig); err != nil {
+			return response(authz.StatusCode(err), err.Error()), nil
+		}
+		return replaceTiers(ctx, request)
+	}
+	return response(405, "Method Not Allowed"), nil
+}
+
+func listTiers(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	all, err := tiers.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load tiers"), nil
+	}
+	return jsonResponse(200, TiersResponse{Tiers: all}), nil
+}
+
+// replaceTiers saves the whole ladder at once, so thresholds never overlap
+// halfway through an edit. Users move to their new tier on the next
+// reconcile run.
+func replaceTiers(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var body TiersResponse
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		if errors.Is(err, amount.ErrPrecision) {
+			return response(400, "min_kg allows 3 decimal places"), nil
+		}
+		return response(400, "Invalid request body"), nil
+	}
+	for i := range body.Tiers {
+		if body.Tiers[i].Perks == nil {
+			body.Tiers[i].Perks = []string{}
+		}
+	}
+	if err := tiers.Validate(body.Tiers); err != nil {
+		return response(400, err.Error()), nil
+	}
+
+	prev, err := tiers.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load tiers"), nil
+	}
+	items := tiers.ReplaceItems(tableName, prev, body.Tiers)
+	if len(items) > 100 {
+		return response(400, "Too many tiers"), nil
+	}
+	_, err = dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to save tiers"), nil
+	}
+	return jsonResponse(200, TiersResponse{Tiers: tiers.Sorted(body.Tiers)}), nil
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
+		"Access-Control-Allow-Methods": "GET,PUT,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -16,18 +17,97 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/amount"
+	"hello-world/internal/authz"
+	"hello-world/internal/codes"
+	"hello-world/internal/cursor"
+	"hello-world/internal/dberr"
+	"hello-world/internal/discount"
+	"hello-world/internal/eligibility"
+	"hello-world/internal/idempotency"
+	"hello-world/internal/ids"
+	"hello-world/internal/ledger"
+	"hello-world/internal/limits"
+	"hello-world/internal/tiers"
+	"hello-world/internal/validity"
 )
 
 type Voucher struct {
-	ID             string  `json:"id"`
-	Title          string  `json:"title"`
-	Discount       string  `json:"discount"`
-	PointsRequired float64 `json:"points_required"`
-	ExpiresAt      string  `json:"expires_at"`
-	Code           string  `json:"code"`
-	Status         string  `json:"status"`
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Discount string `json:"discount"`
+	Category string `json:"category,omitempty"`
+	// DiscountRule is what the voucher takes off an order; Discount is its
+	// display form. Definitions created before rules may only have Discount.
+	DiscountRule   *discount.Discount `json:"discount_rule,omitempty"`
+	PointsRequired amount.Points      `json:"points_required"`
+	StartsAt       string             `json:"starts_at,omitempty"` // Redeemable from, RFC3339
+	ExpiresAt      string             `json:"expires_at"`          // Last valid instant, RFC3339
+	Code           string             `json:"code"`
+	Status         string             `json:"status"`
+	MinTier        string             `json:"min_tier,omitempty"` // Reserved for this tier and above
+	Locked         bool               `json:"locked,omitempty"`   // The caller's tier is too low, or the caller is not eligible
+	LockedReason   string             `json:"locked_reason,omitempty"`
+	// Eligibility restricts the voucher to some members; absent is everyone
+	Eligibility *eligibility.Rule `json:"eligibility,omitempty"`
+	// Stock of a limited offer; both are absent for unlimited vouchers
+	TotalQuantity *int64 `json:"total_quantity,omitempty"`
+	Remaining     *int64 `json:"remaining,omitempty"`
+	SoldOut       bool   `json:"sold_out,omitempty"`
+	CodePool      bool   `json:"code_pool,omitempty"` // Each redeemer gets a unique code from the pool
+	// Limits caps redemptions per user and per day; absent is unlimited
+	Limits *limits.Limits `json:"limits,omitempty"`
+	// Version is bumped by every edit; PUT and DELETE must send the version
+	// they read. Definitions created before versioning report 0.
+	Version int64 `json:"version"`
+	// Availability is derived from StartsAt and ExpiresAt at listing time
+	Availability validity.State `json:"availability"`
+}
+
+// VoucherUpdate is the body of PUT /vouchers/{id}. Fields left out are
+// unchanged; an empty min_tier opens the voucher to every tier.
+type VoucherUpdate struct {
+	Title          *string            `json:"title"`
+	Discount       *string            `json:"discount"`
+	Category       *string            `json:"category"`
+	DiscountRule   *discount.Discount `json:"discount_rule"`
+	PointsRequired *amount.Points     `json:"points_required"`
+	StartsAt       *string            `json:"starts_at"`
+	ExpiresAt      *string            `json:"expires_at"`
+	MinTier        *string            `json:"min_tier"`
+	Limits         *limits.Limits     `json:"limits"`      // Replaces every limit; {} removes them
+	Eligibility    *eligibility.Rule  `json:"eligibility"` // Replaces the rule; {} opens the voucher to everyone
+	Status         *string            `json:"status"`      // active or archived
+	Version        *int64             `json:"version"`
+}
+
+const (
+	statusActive   = "active"
+	statusArchived = "archived" // Hidden and no longer redeemable; claimed vouchers stay valid
+)
+
+// CodesRequest adds codes to a voucher's pool: either uploaded or generated.
+type CodesRequest struct {
+	Codes    []string `json:"codes,omitempty"`
+	Generate int      `json:"generate,omitempty"`
+	Prefix   string   `json:"prefix,omitempty"`
+}
+
+type CodesResponse struct {
+	Message    string   `json:"message"`
+	BatchID    string   `json:"batch_id"`
+	Added      int      `json:"added"`
+	Duplicates []string `json:"duplicates,omitempty"`
 }
 
+// maxCodesPerRequest bounds one upload; each chunk of codesPerTransaction
+// codes is written together with the stock update of the definition.
+const (
+	maxCodesPerRequest  = 1000
+	codesPerTransaction = 99
+)
+
 var dbClient *dynamodb.Client
 var tableName string
 
@@ -38,6 +118,9 @@ func init() {
 	}
 	dbClient = dynamodb.NewFromConfig(cfg)
 	tableName = os.Getenv("TABLE_NAME")
+	if os.Getenv("VOUCHER_EXPIRY_INDEX") != "true" {
+		delete(sortIndexes, "expiry")
+	}
 }
 
 func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -46,8 +129,8 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 	// Enable CORS
 	headers := map[string]string{
 		"Access-Control-Allow-Origin":  "*",
-		"Access-Control-Allow-Methods": "GET,POST,OPTIONS",
-		"Access-Control-Allow-Headers": "Content-Type,Authorization",
+		"Access-Control-Allow-Methods": "GET,POST,PUT,DELETE,OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization,Idempotency-Key",
 	}
 
 	if method == "OPTIONS" {
@@ -63,7 +146,27 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 	}
 
 	if method == "GET" {
-		return listVouchers(ctx, headers, userID)
+		// Admins can ask for archived and expired definitions too, to restore them
+		includeInactive := false
+		params := request.QueryStringParameters
+		if params["include_inactive"] == "true" || params["include_archived"] == "true" {
+			if _, err := authz.Authorize(request, authz.PermManageVouchers); err == nil {
+				includeInactive = true
+			}
+		}
+		return listVouchers(ctx, headers, userID, params, includeInactive)
+	}
+
+	if method == "PUT" {
+		return updateVoucher(ctx, request, headers)
+	}
+
+	if method == "DELETE" {
+		return archiveVoucher(ctx, request, headers)
+	}
+
+	if method == "POST" && strings.HasSuffix(request.Resource, "/codes") {
+		return addCodes(ctx, request, headers)
 	}
 
 	if method == "POST" {
@@ -74,124 +177,320 @@ func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (
 }
 
 type ListResponse struct {
-	Vouchers   []Voucher `json:"vouchers"`
-	UserPoints float64   `json:"user_points"`
+	Vouchers   []Voucher     `json:"vouchers"`
+	UserPoints amount.Points `json:"user_points"`
+	UserTier   string        `json:"user_tier,omitempty"`
+	NextCursor string        `json:"next_cursor,omitempty"`
 }
 
-func listVouchers(ctx context.Context, headers map[string]string, userID string) (events.APIGatewayProxyResponse, error) {
-	// 1. Scan Vouchers
-	out, err := dbClient.Scan(ctx, &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-		FilterExpression: aws.String("PK = :pk"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: "VOUCHER"},
-		},
-	})
-	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+// sortIndexes maps the sort parameter to the index serving it. The table
+// itself orders the catalog by the ULID in SK, i.e. by creation time.
+// VoucherExpiryIndex is created by a deploy of its own, see
+// EnableVoucherExpiryIndex in template.yaml; until then sort=expiry is
+// refused.
+var sortIndexes = map[string]string{
+	"created": "",
+	"points":  "VoucherPointsIndex",
+	"expiry":  "VoucherExpiryIndex",
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// listVouchers pages through the VOUCHER partition. Supported query
+// parameters: category, min_points, max_points, affordable=true (signed in
+// only), sort (created, points or expiry), order=desc, limit and cursor.
+func listVouchers(ctx context.Context, headers map[string]string, userID string, params map[string]string, includeInactive bool) (events.APIGatewayProxyResponse, error) {
+	sortBy := params["sort"]
+	if sortBy == "" {
+		sortBy = "created"
+	}
+	index, ok := sortIndexes[sortBy]
+	if !ok && sortBy == "expiry" {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"sort=expiry is not enabled yet"}`, Headers: headers}, nil
+	}
+	if !ok {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"sort must be created, points or expiry"}`, Headers: headers}, nil
 	}
 
-	var vouchers []Voucher
-	for _, item := range out.Items {
-		v := Voucher{}
-		if val, ok := item["SK"].(*types.AttributeValueMemberS); ok {
-			v.ID = strings.TrimPrefix(val.Value, "DEF#")
-		}
-		if val, ok := item["Title"].(*types.AttributeValueMemberS); ok {
-			v.Title = val.Value
-		}
-		if val, ok := item["Discount"].(*types.AttributeValueMemberS); ok {
-			v.Discount = val.Value
+	limit := defaultPageSize
+	if v := params["limit"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"limit must be a positive integer"}`, Headers: headers}, nil
 		}
-		if val, ok := item["Code"].(*types.AttributeValueMemberS); ok {
-			v.Code = val.Value
-		}
-		if val, ok := item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
-			v.ExpiresAt = val.Value
+		limit = min(n, maxPageSize)
+	}
+
+	startKey, err := cursor.Decode(params["cursor"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Invalid cursor"}`, Headers: headers}, nil
+	}
+
+	var minPoints, maxPoints *amount.Points
+	for name, bound := range map[string]**amount.Points{"min_points": &minPoints, "max_points": &maxPoints} {
+		if v := params[name]; v != "" {
+			p, err := amount.ParsePoints(v)
+			if err != nil || p < 0 {
+				return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"%s must be a non-negative number of points with at most 2 decimals"}`, name), Headers: headers}, nil
+			}
+			*bound = &p
 		}
-		if val, ok := item["Status"].(*types.AttributeValueMemberS); ok {
-			v.Status = val.Value
+	}
+
+	affordable := params["affordable"] == "true"
+	if affordable && userID == "" {
+		return events.APIGatewayProxyResponse{StatusCode: 401, Body: `{"message":"Sign in to list the vouchers you can afford"}`, Headers: headers}, nil
+	}
+
+	// 1. Calculate User Points and Tier if Logged In
+	var userPoints amount.Points
+	userTier := ""
+	member := eligibility.Member{SignedIn: userID != ""}
+	tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Error fetching tiers"}`, Headers: headers}, nil
+	}
+	if userID != "" {
+		balance, err := ledger.LoadBalance(ctx, dbClient, tableName, userID)
+		if err != nil {
+			fmt.Println("Ledger Error:", err)
 		}
-		if val, ok := item["PointsRequired"].(*types.AttributeValueMemberN); ok {
-			f, _ := strconv.ParseFloat(val.Value, 64)
-			v.PointsRequired = f
+		userPoints = balance.Points
+
+		profile, err := ledger.LoadProfile(ctx, dbClient, tableName, userID)
+		if err != nil {
+			fmt.Println("Profile Error:", err)
 		}
-		vouchers = append(vouchers, v)
+		userTier = tiers.Current(tierConfig, profile).Name
+		member.TotalKg, member.Segments, member.Donations = balance.Kg, profile.Segments, balance.Donations
+	}
+	if affordable && (maxPoints == nil || userPoints < *maxPoints) {
+		maxPoints = &userPoints
+	}
+	if minPoints != nil && maxPoints != nil && *minPoints > *maxPoints {
+		// Nothing matches; BETWEEN would reject the reversed range
+		return jsonList(headers, ListResponse{Vouchers: []Voucher{}, UserPoints: userPoints, UserTier: userTier}), nil
 	}
 
-	// 2. Calculate User Points if Logged In
-	userPoints := 0.0
-	if userID != "" {
-		pOut, err := dbClient.Query(ctx, &dynamodb.QueryInput{
-			TableName: aws.String(tableName),
-			KeyConditionExpression: aws.String("PK = :pk"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":pk": &types.AttributeValueMemberS{Value: "USER#" + userID},
-			},
-		})
-		if err == nil {
-			for _, item := range pOut.Items {
-				status := ""
-				if val, ok := item["Status"].(*types.AttributeValueMemberS); ok {
-					status = val.Value
-				}
-				if status == "approved" || status == "" {
-					if pVal, ok := item["PointsEarned"].(*types.AttributeValueMemberN); ok {
-						p, _ := strconv.ParseFloat(pVal.Value, 64)
-						userPoints += p
-					}
-					if pVal, ok := item["PointsSpent"].(*types.AttributeValueMemberN); ok {
-						p, _ := strconv.ParseFloat(pVal.Value, 64)
-						userPoints -= p
+	// 2. Query the catalog
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{
+		":pk": &types.AttributeValueMemberS{Value: "VOUCHER"},
+	}
+	keyCond := "PK = :pk"
+	filters := []string{}
+
+	// The points index takes the range as a key condition, the others filter
+	pointsCond := ""
+	switch {
+	case minPoints != nil && maxPoints != nil:
+		pointsCond = "PointsRequired BETWEEN :minPoints AND :maxPoints"
+	case minPoints != nil:
+		pointsCond = "PointsRequired >= :minPoints"
+	case maxPoints != nil:
+		pointsCond = "PointsRequired <= :maxPoints"
+	}
+	if minPoints != nil {
+		values[":minPoints"] = minPoints.Attr()
+	}
+	if maxPoints != nil {
+		values[":maxPoints"] = maxPoints.Attr()
+	}
+	if pointsCond != "" && sortBy == "points" {
+		keyCond += " AND " + pointsCond
+	} else if pointsCond != "" {
+		filters = append(filters, pointsCond)
+	}
+
+	if category := discount.NormalizeCategory(params["category"]); category != "" {
+		filters = append(filters, "Category = :category")
+		values[":category"] = &types.AttributeValueMemberS{Value: category}
+	}
+	if !includeInactive {
+		filters = append(filters, "#status <> :archived")
+		names["#status"] = "Status"
+		values[":archived"] = &types.AttributeValueMemberS{Value: statusArchived}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		KeyConditionExpression:    aws.String(keyCond),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(params["order"] != "desc"),
+	}
+	if index != "" {
+		input.IndexName = aws.String(index)
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+	if len(names) > 0 {
+		input.ExpressionAttributeNames = names
+	}
+
+	vouchers := []Voucher{}
+	now := time.Now()
+	for {
+		// Filters run after Limit, so keep reading until the page is full
+		input.ExclusiveStartKey = startKey
+		input.Limit = aws.Int32(int32(limit - len(vouchers)))
+		out, err := dbClient.Query(ctx, input)
+		if err != nil {
+			fmt.Println("DynamoDB Query Error:", err)
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+		}
+		for _, item := range out.Items {
+			v, err := toVoucher(item)
+			if err != nil {
+				fmt.Println("Voucher Error:", v.ID, err)
+				return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Invalid voucher data"}`, Headers: headers}, nil
+			}
+			// Scheduled vouchers are listed, flagged, so members can look forward to them
+			v.Availability = validity.FromStrings(v.StartsAt, v.ExpiresAt).At(now)
+			if v.Availability == validity.Expired && !includeInactive {
+				continue
+			}
+			if !tiers.Allows(tierConfig, userTier, v.MinTier) {
+				v.Locked, v.LockedReason = true, fmt.Sprintf("This voucher is reserved for %s members and above", v.MinTier)
+			}
+			// Restricted vouchers stay out of sight unless they ask to be teased
+			if v.Eligibility != nil {
+				if reason := v.Eligibility.Check(member); reason != "" {
+					if !v.Eligibility.ShowLocked && !includeInactive {
+						continue
 					}
+					v.Locked, v.LockedReason = true, reason
 				}
 			}
+			vouchers = append(vouchers, v)
+		}
+		startKey = out.LastEvaluatedKey
+		if startKey == nil || len(vouchers) >= limit {
+			break
 		}
 	}
 
-	resp := ListResponse{
+	next, err := cursor.Encode(startKey)
+	if err != nil {
+		fmt.Println("Cursor Error:", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Failed to list vouchers"}`, Headers: headers}, nil
+	}
+	return jsonList(headers, ListResponse{
 		Vouchers:   vouchers,
 		UserPoints: userPoints,
-	}
+		UserTier:   userTier,
+		NextCursor: next,
+	}), nil
+}
 
+func jsonList(headers map[string]string, resp ListResponse) events.APIGatewayProxyResponse {
 	body, _ := json.Marshal(resp)
-	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: headers}, nil
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: headers}
 }
 
 func createVoucher(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
 	// Verify Admin
-	claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{})
-	if !ok {
-		// Mock logic for local testing or if Auth is misconfigured
-		// For now, strict check
-		// return events.APIGatewayProxyResponse{StatusCode: 401, Body: `{"message":"Unauthorized"}`, Headers: headers}, nil
+	caller, err := authz.Authorize(request, authz.PermManageVouchers)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: authz.StatusCode(err), Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
 	}
-	// Check group/role if needed, for now assume Authenticated = Allowed or check "cognito:groups"
-	_ = claims
 
 	var v Voucher
 	if err := json.Unmarshal([]byte(request.Body), &v); err != nil {
+		if errors.Is(err, amount.ErrPrecision) {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"points_required allows at most 2 decimal places"}`, Headers: headers}, nil
+		}
 		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Invalid Body"}`, Headers: headers}, nil
 	}
+	if v.PointsRequired < 0 {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"points_required must not be negative"}`, Headers: headers}, nil
+	}
+	rule, label, err := resolveDiscount(v.DiscountRule, v.Discount)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+	}
+	v.DiscountRule, v.Discount = &rule, label
+	if v.TotalQuantity != nil && *v.TotalQuantity <= 0 {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"total_quantity must be positive, omit it for unlimited vouchers"}`, Headers: headers}, nil
+	}
+	if v.Eligibility != nil {
+		if err := v.Eligibility.Validate(); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"eligibility: %s"}`, err), Headers: headers}, nil
+		}
+	}
+	if v.Limits != nil {
+		if err := v.Limits.Validate(); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+		}
+	}
+	if v.StartsAt, err = validity.Normalize(v.StartsAt, false); err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"starts_at %s"}`, err), Headers: headers}, nil
+	}
+	if v.ExpiresAt, err = validity.Normalize(v.ExpiresAt, true); err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"expires_at %s"}`, err), Headers: headers}, nil
+	}
+	if err := validity.FromStrings(v.StartsAt, v.ExpiresAt).Validate(time.Now()); err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+	}
+	if v.MinTier != "" {
+		tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+		}
+		t, ok := tiers.Find(tierConfig, v.MinTier)
+		if !ok {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Unknown min_tier"}`, Headers: headers}, nil
+		}
+		v.MinTier = t.Name
+	}
 
 	if v.Code == "" {
 		v.Code = fmt.Sprintf("EC-%d%d", time.Now().Unix()%1000, rand.Intn(999))
 	}
-	id := fmt.Sprintf("DEF#%d", time.Now().UnixNano())
+	id := "DEF#" + ids.New()
 
-	_, err := dbClient.PutItem(ctx, &dynamodb.PutItemInput{
+	item := map[string]types.AttributeValue{
+		"PK":             &types.AttributeValueMemberS{Value: "VOUCHER"},
+		"SK":             &types.AttributeValueMemberS{Value: id},
+		"Title":          &types.AttributeValueMemberS{Value: v.Title},
+		"Discount":       &types.AttributeValueMemberS{Value: v.Discount},
+		"DiscountRule":   v.DiscountRule.Attr(),
+		"Code":           &types.AttributeValueMemberS{Value: v.Code},
+		"PointsRequired": v.PointsRequired.Attr(),
+		"ExpiresAt":      &types.AttributeValueMemberS{Value: v.ExpiresAt},
+		"ExpirySort":     &types.AttributeValueMemberS{Value: validity.SortKey(v.ExpiresAt)},
+		"Status":         &types.AttributeValueMemberS{Value: statusActive},
+		"CreatedAt":      &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
+		"CreatedBy":      &types.AttributeValueMemberS{Value: caller.UserID},
+		"Version":        &types.AttributeValueMemberN{Value: "1"},
+	}
+	if v.TotalQuantity != nil {
+		// Remaining is decremented by redeem, TotalQuantity keeps the original stock
+		item["TotalQuantity"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*v.TotalQuantity, 10)}
+		item["Remaining"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*v.TotalQuantity, 10)}
+	}
+	if v.Category = discount.NormalizeCategory(v.Category); v.Category != "" {
+		item["Category"] = &types.AttributeValueMemberS{Value: v.Category}
+	}
+	if v.Limits != nil && !v.Limits.IsZero() {
+		item["Limits"] = v.Limits.Attr()
+	}
+	if v.Eligibility != nil && !v.Eligibility.IsZero() {
+		item["Eligibility"] = v.Eligibility.Attr()
+	}
+	if v.StartsAt != "" {
+		item["StartsAt"] = &types.AttributeValueMemberS{Value: v.StartsAt}
+	}
+	if v.MinTier != "" {
+		item["MinTier"] = &types.AttributeValueMemberS{Value: v.MinTier}
+	}
+	_, err = dbClient.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(tableName),
-		Item: map[string]types.AttributeValue{
-			"PK":             &types.AttributeValueMemberS{Value: "VOUCHER"},
-			"SK":             &types.AttributeValueMemberS{Value: id},
-			"Title":          &types.AttributeValueMemberS{Value: v.Title},
-			"Discount":       &types.AttributeValueMemberS{Value: v.Discount},
-			"Code":           &types.AttributeValueMemberS{Value: v.Code},
-			"PointsRequired": &types.AttributeValueMemberN{Value: fmt.Sprintf("%f", v.PointsRequired)},
-			"ExpiresAt":      &types.AttributeValueMemberS{Value: v.ExpiresAt},
-			"Status":         &types.AttributeValueMemberS{Value: "active"},
-			"CreatedAt":      &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
-		},
+		Item:      item,
 	})
 
 	if err != nil {
@@ -201,6 +500,435 @@ func createVoucher(ctx context.Context, request events.APIGatewayProxyRequest, h
 	return events.APIGatewayProxyResponse{StatusCode: 201, Body: `{"message":"Voucher Created"}`, Headers: headers}, nil
 }
 
+// addCodes uploads or generates unique codes for a voucher. Every code adds
+// one unit of stock, so Remaining always matches the unclaimed codes. A
+// voucher created with a plain total_quantity cannot take codes: its
+// Remaining would count units no code stands for.
+func addCodes(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	if _, err := authz.Authorize(request, authz.PermManageVouchers); err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: authz.StatusCode(err), Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+	}
+
+	voucherSK := "DEF#" + strings.TrimPrefix(request.PathParameters["id"], "DEF#")
+	var body CodesRequest
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Invalid Body"}`, Headers: headers}, nil
+	}
+	if (len(body.Codes) == 0) == (body.Generate <= 0) {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Send either codes or generate"}`, Headers: headers}, nil
+	}
+	if len(body.Codes) > maxCodesPerRequest || body.Generate > maxCodesPerRequest {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"At most %d codes per request"}`, maxCodesPerRequest), Headers: headers}, nil
+	}
+
+	list := body.Codes
+	if body.Generate > 0 {
+		if err := codes.Check(codes.Normalize(body.Prefix) + "-X"); err != nil || len(body.Prefix) > 16 {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"prefix allows up to 16 letters and digits"}`, Headers: headers}, nil
+		}
+		var err error
+		if list, err = codes.Generate(body.Prefix, body.Generate); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Failed to generate codes"}`, Headers: headers}, nil
+		}
+	}
+	seen := map[string]bool{}
+	for i, c := range list {
+		c = codes.Normalize(c)
+		if err := codes.Check(c); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+		}
+		if seen[c] {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"Code %s appears twice"}`, c), Headers: headers}, nil
+		}
+		seen[c] = true
+		list[i] = c
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	resp := CodesResponse{Message: "Codes Added", BatchID: ids.New()}
+	for start := 0; start < len(list); start += codesPerTransaction {
+		chunk := list[start:min(start+codesPerTransaction, len(list))]
+		items := make([]types.TransactWriteItem, 0, len(chunk)+1)
+		for _, c := range chunk {
+			items = append(items, codes.PutItem(tableName, voucherSK, resp.BatchID, c, now))
+		}
+		items = append(items, poolStockItem(voucherSK, len(chunk)))
+
+		_, err := dbClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if dberr.ConditionFailedAt(err, len(chunk)) {
+			def, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+				TableName: aws.String(tableName),
+				Key: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: "VOUCHER"},
+					"SK": &types.AttributeValueMemberS{Value: voucherSK},
+				},
+			})
+			if err != nil {
+				return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+			}
+			status, message := poolConflict(def.Item)
+			return events.APIGatewayProxyResponse{StatusCode: status, Body: fmt.Sprintf(`{"message":%q}`, message), Headers: headers}, nil
+		}
+		if dberr.ConditionFailed(err) {
+			// Earlier chunks are kept; report what was added and what clashed
+			for _, i := range dberr.ConditionFailedItems(err) {
+				resp.Duplicates = append(resp.Duplicates, chunk[i])
+			}
+			resp.Message = "Some codes already exist"
+			body, _ := json.Marshal(resp)
+			return events.APIGatewayProxyResponse{StatusCode: 409, Body: string(body), Headers: headers}, nil
+		}
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+		}
+		resp.Added += len(chunk)
+	}
+
+	out, _ := json.Marshal(resp)
+	return events.APIGatewayProxyResponse{StatusCode: 201, Body: string(out), Headers: headers}, nil
+}
+
+// poolStockItem adds n codes to the stock of a definition that is unlimited
+// or already pooled.
+func poolStockItem(voucherSK string, n int) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: "VOUCHER"},
+			"SK": &types.AttributeValueMemberS{Value: voucherSK},
+		},
+		UpdateExpression:    aws.String("ADD TotalQuantity :n, Remaining :n SET CodePool = :true"),
+		ConditionExpression: aws.String("attribute_exists(SK) AND (attribute_not_exists(Remaining) OR CodePool = :true)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":n":    &types.AttributeValueMemberN{Value: strconv.Itoa(n)},
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+		},
+	}}
+}
+
+// poolConflict explains why poolStockItem failed on def.
+func poolConflict(def map[string]types.AttributeValue) (int, string) {
+	if def == nil {
+		return 404, "Voucher not found"
+	}
+	return 409, "Voucher has a total_quantity without codes; codes can only be added to unlimited or pooled vouchers"
+}
+
+// updateVoucher edits a definition. Vouchers already claimed keep the
+// copy of the title, discount and expiry made at redemption.
+func updateVoucher(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	caller, err := authz.Authorize(request, authz.PermManageVouchers)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: authz.StatusCode(err), Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+	}
+
+	var body VoucherUpdate
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		if errors.Is(err, amount.ErrPrecision) {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"points_required allows at most 2 decimal places"}`, Headers: headers}, nil
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Invalid Body"}`, Headers: headers}, nil
+	}
+	if body.Version == nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"version is required"}`, Headers: headers}, nil
+	}
+
+	sets := []string{}
+	values := map[string]types.AttributeValue{}
+	set := func(attr string, v types.AttributeValue) {
+		sets = append(sets, fmt.Sprintf("%s = :%s", attr, strings.ToLower(attr)))
+		values[":"+strings.ToLower(attr)] = v
+	}
+	if body.Title != nil {
+		if strings.TrimSpace(*body.Title) == "" {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"title must not be empty"}`, Headers: headers}, nil
+		}
+		set("Title", &types.AttributeValueMemberS{Value: *body.Title})
+	}
+	if body.DiscountRule != nil || body.Discount != nil {
+		label := ""
+		if body.Discount != nil {
+			label = *body.Discount
+		}
+		rule, label, err := resolveDiscount(body.DiscountRule, label)
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+		}
+		set("Discount", &types.AttributeValueMemberS{Value: label})
+		set("DiscountRule", rule.Attr())
+	}
+	if body.PointsRequired != nil {
+		if *body.PointsRequired < 0 {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"points_required must not be negative"}`, Headers: headers}, nil
+		}
+		set("PointsRequired", body.PointsRequired.Attr())
+	}
+	removes := []string{}
+	if body.StartsAt != nil || body.ExpiresAt != nil {
+		// Check the window as it will be, merging in the stored bound
+		current, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(tableName), Key: voucherKey(request)})
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+		}
+		if current.Item == nil {
+			return events.APIGatewayProxyResponse{StatusCode: 404, Body: `{"message":"Voucher not found"}`, Headers: headers}, nil
+		}
+		stored, _ := toVoucher(current.Item)
+		startsAt, expiresAt := stored.StartsAt, stored.ExpiresAt
+		if body.StartsAt != nil {
+			if startsAt, err = validity.Normalize(*body.StartsAt, false); err != nil {
+				return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"starts_at %s"}`, err), Headers: headers}, nil
+			}
+			if startsAt == "" {
+				removes = append(removes, "StartsAt")
+			} else {
+				set("StartsAt", &types.AttributeValueMemberS{Value: startsAt})
+			}
+		}
+		if body.ExpiresAt != nil {
+			if expiresAt, err = validity.Normalize(*body.ExpiresAt, true); err != nil {
+				return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"expires_at %s"}`, err), Headers: headers}, nil
+			}
+			set("ExpiresAt", &types.AttributeValueMemberS{Value: expiresAt})
+			set("ExpirySort", &types.AttributeValueMemberS{Value: validity.SortKey(expiresAt)})
+		}
+		if err := validity.FromStrings(startsAt, expiresAt).Validate(time.Now()); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+		}
+	}
+	if body.Category != nil {
+		if category := discount.NormalizeCategory(*body.Category); category == "" {
+			removes = append(removes, "Category")
+		} else {
+			set("Category", &types.AttributeValueMemberS{Value: category})
+		}
+	}
+	if body.Limits != nil {
+		if err := body.Limits.Validate(); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+		}
+		if body.Limits.IsZero() {
+			removes = append(removes, "Limits")
+		} else {
+			set("Limits", body.Limits.Attr())
+		}
+	}
+	if body.Eligibility != nil {
+		if err := body.Eligibility.Validate(); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: fmt.Sprintf(`{"message":"eligibility: %s"}`, err), Headers: headers}, nil
+		}
+		if body.Eligibility.IsZero() {
+			removes = append(removes, "Eligibility")
+		} else {
+			set("Eligibility", body.Eligibility.Attr())
+		}
+	}
+	if body.Status != nil {
+		if *body.Status != statusActive && *body.Status != statusArchived {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"status must be active or archived"}`, Headers: headers}, nil
+		}
+		sets = append(sets, "#status = :status")
+		values[":status"] = &types.AttributeValueMemberS{Value: *body.Status}
+	}
+	if body.MinTier != nil {
+		if *body.MinTier == "" {
+			removes = append(removes, "MinTier")
+		} else {
+			tierConfig, err := tiers.Load(ctx, dbClient, tableName)
+			if err != nil {
+				return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+			}
+			t, ok := tiers.Find(tierConfig, *body.MinTier)
+			if !ok {
+				return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Unknown min_tier"}`, Headers: headers}, nil
+			}
+			set("MinTier", &types.AttributeValueMemberS{Value: t.Name})
+		}
+	}
+	remove := ""
+	if len(removes) > 0 {
+		remove = " REMOVE " + strings.Join(removes, ", ")
+	}
+	if len(sets) == 0 && remove == "" {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"Nothing to update"}`, Headers: headers}, nil
+	}
+	return writeVersioned(ctx, request, headers, caller, *body.Version, sets, remove, values)
+}
+
+// archiveVoucher is DELETE /vouchers/{id}?version=N. The definition is kept
+// so claimed vouchers and history still resolve their VoucherRef; it is
+// hidden from the catalogue and can no longer be redeemed.
+func archiveVoucher(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	caller, err := authz.Authorize(request, authz.PermManageVouchers)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: authz.StatusCode(err), Body: fmt.Sprintf(`{"message":%q}`, err.Error()), Headers: headers}, nil
+	}
+	version, err := strconv.ParseInt(request.QueryStringParameters["version"], 10, 64)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: `{"message":"version query parameter is required"}`, Headers: headers}, nil
+	}
+	sets := []string{"#status = :status"}
+	values := map[string]types.AttributeValue{
+		":status": &types.AttributeValueMemberS{Value: statusArchived},
+	}
+	return writeVersioned(ctx, request, headers, caller, version, sets, "", values)
+}
+
+// writeVersioned applies an edit if the definition is still at version.
+func writeVersioned(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string, caller authz.Identity, version int64, sets []string, remove string, values map[string]types.AttributeValue) (events.APIGatewayProxyResponse, error) {
+	key := voucherKey(request)
+
+	sets = append(sets, "Version = :next", "UpdatedAt = :t", "UpdatedBy = :by")
+	values[":next"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)}
+	values[":t"] = &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)}
+	values[":by"] = &types.AttributeValueMemberS{Value: caller.UserID}
+	condition := "attribute_exists(SK) AND attribute_not_exists(Version)"
+	if version > 0 {
+		condition = "attribute_exists(SK) AND Version = :v"
+		values[":v"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)}
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       key,
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ") + remove),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	}
+	if _, ok := values[":status"]; ok {
+		input.ExpressionAttributeNames = map[string]string{"#status": "Status"}
+	}
+
+	out, err := dbClient.UpdateItem(ctx, input)
+	if dberr.ConditionFailed(err) {
+		current, getErr := dbClient.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(tableName), Key: key})
+		if getErr == nil && current.Item == nil {
+			return events.APIGatewayProxyResponse{StatusCode: 404, Body: `{"message":"Voucher not found"}`, Headers: headers}, nil
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 409, Body: `{"message":"Voucher was changed by someone else, reload and try again"}`, Headers: headers}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: fmt.Sprintf(`{"message":"DB Error: %v"}`, err), Headers: headers}, nil
+	}
+
+	v, err := toVoucher(out.Attributes)
+	if err != nil {
+		fmt.Println("Voucher Error:", v.ID, err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"message":"Invalid voucher data"}`, Headers: headers}, nil
+	}
+	body, _ := json.Marshal(v)
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body), Headers: headers}, nil
+}
+
+// resolveDiscount validates the rule of a create or update. Without a rule,
+// one is parsed from the display string, as the admin UI still sends "8%";
+// without a display string, the rule's label is used.
+func resolveDiscount(rule *discount.Discount, label string) (discount.Discount, string, error) {
+	if rule == nil {
+		parsed, err := discount.Parse(label)
+		if err != nil {
+			return discount.Discount{}, "", fmt.Errorf("%v, or send discount_rule", err)
+		}
+		rule = &parsed
+	} else if err := rule.Validate(); err != nil {
+		return discount.Discount{}, "", fmt.Errorf("discount_rule: %v", err)
+	}
+	if strings.TrimSpace(label) == "" {
+		label = rule.Label()
+	}
+	return *rule, label, nil
+}
+
+func voucherKey(request events.APIGatewayProxyRequest) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: "VOUCHER"},
+		"SK": &types.AttributeValueMemberS{Value: "DEF#" + strings.TrimPrefix(request.PathParameters["id"], "DEF#")},
+	}
+}
+
+func toVoucher(item map[string]types.AttributeValue) (Voucher, error) {
+	v := Voucher{}
+	if val, ok := item["SK"].(*types.AttributeValueMemberS); ok {
+		v.ID = strings.TrimPrefix(val.Value, "DEF#")
+	}
+	if val, ok := item["Title"].(*types.AttributeValueMemberS); ok {
+		v.Title = val.Value
+	}
+	if val, ok := item["Discount"].(*types.AttributeValueMemberS); ok {
+		v.Discount = val.Value
+	}
+	if val, ok := item["Code"].(*types.AttributeValueMemberS); ok {
+		v.Code = val.Value
+	}
+	if r, err := eligibility.FromItem(item, "Eligibility"); err != nil {
+		return v, err
+	} else if !r.IsZero() {
+		v.Eligibility = &r
+	}
+	if l, err := limits.FromItem(item, "Limits"); err != nil {
+		return v, err
+	} else if !l.IsZero() {
+		v.Limits = &l
+	}
+	if rule, ok, err := discount.FromItem(item, "DiscountRule"); err != nil {
+		return v, err
+	} else if ok {
+		v.DiscountRule = &rule
+	}
+	if val, ok := item["Category"].(*types.AttributeValueMemberS); ok {
+		v.Category = val.Value
+	}
+	if val, ok := item["StartsAt"].(*types.AttributeValueMemberS); ok {
+		v.StartsAt = val.Value
+	}
+	if val, ok := item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		v.ExpiresAt = val.Value
+	}
+	if val, ok := item["Status"].(*types.AttributeValueMemberS); ok {
+		v.Status = val.Value
+	}
+	if val, ok := item["MinTier"].(*types.AttributeValueMemberS); ok {
+		v.MinTier = val.Value
+	}
+	var err error
+	if v.TotalQuantity, err = intAttr(item, "TotalQuantity"); err != nil {
+		return v, err
+	}
+	if v.Remaining, err = intAttr(item, "Remaining"); err != nil {
+		return v, err
+	}
+	v.SoldOut = v.Remaining != nil && *v.Remaining <= 0
+	if val, ok := item["CodePool"].(*types.AttributeValueMemberBOOL); ok && val.Value {
+		// The shared code is not valid for pooled vouchers
+		v.CodePool, v.Code = true, ""
+	}
+	version, err := intAttr(item, "Version")
+	if err != nil {
+		return v, err
+	}
+	if version != nil {
+		v.Version = *version
+	}
+	if v.PointsRequired, err = amount.PointsAttr(item, "PointsRequired"); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// intAttr reads an optional whole number, nil when the attribute is absent.
+func intAttr(item map[string]types.AttributeValue, name string) (*int64, error) {
+	v, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v.Value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %w", name, v.Value, err)
+	}
+	return &n, nil
+}
+
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(idempotency.Middleware(dbClient, tableName, handleRequest))
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestPoolStock(t *testing.T) {
+	update := poolStockItem("DEF#01", 3).Update
+	expected := "attribute_exists(SK) AND (attribute_not_exists(Remaining) OR CodePool = :true)"
+	if *update.ConditionExpression != expected {
+		t.Errorf("Expected condition %q, but got %q", expected, *update.ConditionExpression)
+	}
+
+	testCases := []struct {
+		name           string
+		def            map[string]types.AttributeValue
+		expectedStatus int
+	}{
+		{
+			name:           "missing definition",
+			def:            nil,
+			expectedStatus: 404,
+		},
+		{
+			// Remaining counts units no code stands for
+			name:           "definition with a plain total_quantity",
+			def:            map[string]types.AttributeValue{"Remaining": &types.AttributeValueMemberN{Value: "10"}},
+			expectedStatus: 409,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if status, _ := poolConflict(testCase.def); status != testCase.expectedStatus {
+				t.Errorf("Expected status %d, but got %d", testCase.expectedStatus, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"hello-world/internal/authz"
+	"hello-world/internal/cursor"
+	"hello-world/internal/ledger"
+	"hello-world/internal/wallet"
+)
+
+type ListResponse struct {
+	Vouchers   []wallet.Voucher `json:"vouchers"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+var dbClient *dynamodb.Client
+var tableName string
+
+func init() {
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		panic("Cannot load AWS config")
+	}
+	dbClient = dynamodb.NewFromConfig(cfg)
+	tableName = os.Getenv("TABLE_NAME")
+}
+
+// handleRequest serves the caller's own vouchers: GET /me/vouchers and
+// GET /me/vouchers/{voucherId}.
+func handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if request.HTTPMethod == "OPTIONS" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Headers: corsHeaders()}, nil
+	}
+	if request.HTTPMethod != "GET" {
+		return response(405, "Method Not Allowed"), nil
+	}
+	caller, err := authz.FromRequest(request)
+	if err != nil {
+		return response(authz.StatusCode(err), err.Error()), nil
+	}
+
+	if id := request.PathParameters["voucherId"]; id != "" {
+		return getVoucher(ctx, caller.UserID, id)
+	}
+	return listVouchers(ctx, caller.UserID, request.QueryStringParameters)
+}
+
+// listVouchers returns the caller's vouchers, most recently claimed first.
+// Supported query parameters: status (active, used, expired or void;
+// default all), limit and cursor.
+func listVouchers(ctx context.Context, userID string, params map[string]string) (events.APIGatewayProxyResponse, error) {
+	status := params["status"]
+	if status != "" && !wallet.IsStatus(status) {
+		return response(400, "status must be active, used, expired or void"), nil
+	}
+
+	limit := defaultPageSize
+	if v := params["limit"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return response(400, "limit must be a positive integer"), nil
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	startKey, err := cursor.Decode(params["cursor"])
+	if err != nil {
+		return response(400, "Invalid cursor"), nil
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :v)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+			":v":  &types.AttributeValueMemberS{Value: wallet.SKPrefix},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	// Vouchers past their expiry stay active until the daily job runs, so
+	// active and expired read active items too and are settled by Refresh.
+	// Items without a Status are active, see wallet.FromItem.
+	switch status {
+	case wallet.StatusActive:
+		input.FilterExpression = aws.String("attribute_not_exists(#status) OR #status = :active")
+	case wallet.StatusUsed:
+		input.FilterExpression = aws.String("#status = :used")
+	case wallet.StatusVoid:
+		input.FilterExpression = aws.String("#status = :void")
+	case wallet.StatusExpired:
+		input.FilterExpression = aws.String("attribute_not_exists(#status) OR #status IN (:active, :expired)")
+	}
+	if status != "" {
+		input.ExpressionAttributeNames = map[string]string{"#status": "Status"}
+		input.ExpressionAttributeValues[":"+status] = &types.AttributeValueMemberS{Value: status}
+		if status == wallet.StatusExpired {
+			input.ExpressionAttributeValues[":active"] = &types.AttributeValueMemberS{Value: wallet.StatusActive}
+		}
+	}
+
+	now := time.Now()
+	vouchers := []wallet.Voucher{}
+	for {
+		// Filters run after Limit, so keep reading until the page is full
+		input.ExclusiveStartKey = startKey
+		input.Limit = aws.Int32(int32(limit - len(vouchers)))
+		out, err := dbClient.Query(ctx, input)
+		if err != nil {
+			fmt.Println("DynamoDB Query Error:", err)
+			return response(500, "System Error: Failed to list vouchers"), nil
+		}
+		for _, item := range out.Items {
+			v, err := wallet.FromItem(item)
+			if err != nil {
+				fmt.Println("Wallet Error:", err)
+				return response(500, "System Error: Invalid voucher record"), nil
+			}
+			v.Refresh(now)
+			if status == "" || v.Status == status {
+				vouchers = append(vouchers, v)
+			}
+		}
+		startKey = out.LastEvaluatedKey
+		if startKey == nil || len(vouchers) >= limit {
+			break
+		}
+	}
+
+	next, err := cursor.Encode(startKey)
+	if err != nil {
+		fmt.Println("Cursor Error:", err)
+		return response(500, "System Error: Failed to list vouchers"), nil
+	}
+	return jsonResponse(200, ListResponse{Vouchers: vouchers, NextCursor: next}), nil
+}
+
+func getVoucher(ctx context.Context, userID, id string) (events.APIGatewayProxyResponse, error) {
+	out, err := dbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: ledger.UserPK(userID)},
+			"SK": &types.AttributeValueMemberS{Value: wallet.SK(id)},
+		},
+	})
+	if err != nil {
+		fmt.Println("DynamoDB Error:", err)
+		return response(500, "System Error: Failed to load voucher"), nil
+	}
+	if t, ok := out.Item["Type"].(*types.AttributeValueMemberS); !ok || t.Value != wallet.Type {
+		return response(404, "Voucher not found"), nil
+	}
+	v, err := wallet.FromItem(out.Item)
+	if err != nil {
+		fmt.Println("Wallet Error:", err)
+		return response(500, "System Error: Invalid voucher record"), nil
+	}
+	v.Refresh(time.Now())
+	return jsonResponse(200, v), nil
+}
+
+func corsHeaders() map[string]string {
+	return map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Headers": "Content-Type,Authorization",
+		"Access-Control-Allow-Methods": "GET,OPTIONS",
+	}
+}
+
+func jsonResponse(status int, body interface{}) events.APIGatewayProxyResponse {
+	jsonBody, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(jsonBody),
+		StatusCode: status,
+		Headers:    corsHeaders(),
+	}
+}
+
+func response(status int, message string) events.APIGatewayProxyResponse {
+	return jsonResponse(status, map[string]string{"message": message})
+}
+
+func main() {
+	lambda.Start(handleRequest)
+}
@@ -32,8 +32,8 @@ type RewardsState = {
   updatePointsPerKg: (value: number) => void;
 
   addVoucher: (voucher: Omit<Voucher, 'id' | 'status'>) => Promise<boolean>;
-  deleteVoucher: (id: string) => void; // TODO: API
-  editVoucher: (voucher: Voucher) => void; // TODO: API
+  deleteVoucher: (id: string) => Promise<boolean>;
+  editVoucher: (voucher: Voucher) => Promise<boolean>;
 
   updateDonationStatus: (userId: string, entryId: string, status: 'approved' | 'rejected') => void;
   adjustUserPoints: (userId: string, points: number, reason: string) => void;
@@ -126,31 +126,41 @@ export function RewardsProvider({ children }: { children: ReactNode }) {
       const headers: Record<string, string> = { 'Content-Type': 'application/json' };
       if (token) headers['Authorization'] = token;
 
-      const res = await fetch(`${API_BASE}/vouchers`, { headers });
-      if (res.ok) {
+      // The catalog is paginated; follow next_cursor until the last page
+      let rawVouchers: any[] = [];
+      let backendPoints = 0;
+      let cursor: string | undefined;
+      let res: Response;
+      do {
+        const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+        res = await fetch(`${API_BASE}/vouchers${query}`, { headers });
+        if (!res.ok) break;
         const data = await res.json();
 
-        // Handle new response format { vouchers: [], user_points: number }
-        let rawVouchers = [];
-        let backendPoints = 0;
-
+        // Handle new response format { vouchers: [], user_points: number, next_cursor?: string }
         if (Array.isArray(data)) {
           // Legacy fallback
           rawVouchers = data;
+          cursor = undefined;
         } else if (data && typeof data === 'object') {
-          rawVouchers = data.vouchers || [];
+          rawVouchers = rawVouchers.concat(data.vouchers || []);
           backendPoints = data.user_points || 0;
+          cursor = data.next_cursor;
         }
+      } while (cursor);
+      if (res.ok) {
 
         // Map snake_case from Backend to camelCase for Frontend
         const mapped = Array.isArray(rawVouchers) ? rawVouchers.map((v: any) => ({
           id: v.id,
           title: v.title,
           discount: v.discount,
+          category: v.category,
           code: v.code,
           status: v.status,
           pointsRequired: v.points_required || v.pointsRequired,
-          expiresAt: v.expires_at || v.expiresAt
+          expiresAt: v.expires_at || v.expiresAt,
+          version: v.version
         })) : [];
 
         setAvailableVouchers(mapped);
@@ -188,6 +198,57 @@ export function RewardsProvider({ children }: { children: ReactNode }) {
     fetchVouchers();
   }, []);
 
+  // --- FETCH PROFILE ---
+  // Balance, history and claimed vouchers come from GET /profile
+  const fetchProfile = useCallback(async () => {
+    if (!isAuthenticated || !currentUserId || currentUserId === 'guest') return;
+    const API_BASE = getApiBase();
+    if (!API_BASE) return;
+    const token = await getAuthToken();
+    if (!token) return;
+
+    try {
+      // History is paginated; follow nextCursor until the last page
+      let profile: UserRewardProfile | undefined;
+      let history: RewardHistoryEntry[] = [];
+      let cursor: string | undefined;
+      do {
+        const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+        const res = await fetch(`${API_BASE}/profile${query}`, {
+          headers: { 'Content-Type': 'application/json', 'Authorization': token }
+        });
+        if (!res.ok) return;
+        const data = await res.json();
+        profile = profile || data;
+        history = history.concat(data.history || []);
+        cursor = data.nextCursor;
+      } while (cursor);
+      if (!profile) return;
+      const { name, email, points, totalKg, claimedVouchers } = profile;
+
+      setUsersDb(prev => ({
+        ...prev,
+        [currentUserId]: {
+          ...prev[currentUserId],
+          id: currentUserId,
+          name,
+          email,
+          points,
+          totalKg,
+          history,
+          claimedVouchers: claimedVouchers || []
+        }
+      }));
+    } catch (e) {
+      console.error("Fetch profile failed", e);
+    }
+  }, [isAuthenticated, currentUserId]);
+
+  // Load profile once signed in
+  useEffect(() => {
+    fetchProfile();
+  }, [fetchProfile]);
+
 
   // --- ADD DONATION ---
   const addDonation = useCallback(async (kg: number, note = 'Quyên góp') => {
@@ -377,10 +438,63 @@ export function RewardsProvider({ children }: { children: ReactNode }) {
   }, [fetchVouchers]);
 
 
+  // --- ADMIN EDIT / ARCHIVE VOUCHER ---
+  // Both send the version that was listed; 409 means another admin saved first
+  // Only changed fields are sent: the server re-validates every field it
+  // receives, so resending a legacy discount or a past expiry would fail
+  const editVoucher = useCallback(async (voucher: Voucher) => {
+    const token = await getAuthToken();
+    if (!token) return false;
+    const API_BASE = getApiBase();
+    const listed = availableVouchers.find(v => v.id === voucher.id);
+
+    const changes: Record<string, string | number> = {};
+    if (voucher.title !== listed?.title) changes.title = voucher.title;
+    if (voucher.discount !== listed?.discount) changes.discount = voucher.discount;
+    if (voucher.pointsRequired !== listed?.pointsRequired) changes.points_required = voucher.pointsRequired;
+    if (voucher.expiresAt !== listed?.expiresAt) changes.expires_at = voucher.expiresAt;
+    if (Object.keys(changes).length === 0) return true;
+
+    try {
+      const res = await fetch(`${API_BASE}/vouchers/${encodeURIComponent(voucher.id)}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', 'Authorization': token },
+        body: JSON.stringify({ ...changes, version: voucher.version ?? 0 })
+      });
+      fetchVouchers();
+      if (res.ok) return true;
+      alert(res.status === 409 ? "Voucher vừa được người khác sửa, vui lòng thử lại" : "Lỗi cập nhật voucher");
+      return false;
+    } catch (e) {
+      console.error(e);
+      return false;
+    }
+  }, [availableVouchers, fetchVouchers]);
+
+  const deleteVoucher = useCallback(async (id: string) => {
+    const token = await getAuthToken();
+    if (!token) return false;
+    const API_BASE = getApiBase();
+    const version = availableVouchers.find(v => v.id === id)?.version ?? 0;
+
+    try {
+      const res = await fetch(`${API_BASE}/vouchers/${encodeURIComponent(id)}?version=${version}`, {
+        method: 'DELETE',
+        headers: { 'Authorization': token }
+      });
+      fetchVouchers();
+      if (res.ok) return true;
+      alert(res.status === 409 ? "Voucher vừa được người khác sửa, vui lòng thử lại" : "Lỗi xóa voucher");
+      return false;
+    } catch (e) {
+      console.error(e);
+      return false;
+    }
+  }, [availableVouchers, fetchVouchers]);
+
+
   // --- LEGACY STUBS for Context Compatibility ---
   const updatePointsPerKg = (v: number) => setConfig(p => ({ ...p, pointsPerKg: v }));
-  const deleteVoucher = (id: string) => { console.log("Delete not impl in Main Branch yet"); };
-  const editVoucher = (v: Voucher) => { console.log("Edit not impl"); };
   const updateDonationStatus = (userId: string, entryId: string, status: 'approved' | 'rejected') => {
     setUsersDb(prev => {
       const userProfile = prev[userId];
@@ -480,7 +594,7 @@ export function RewardsProvider({ children }: { children: ReactNode }) {
     return true;
   }, [isAuthenticated, config.pointsPerKg]);
 
-  const refreshData = () => { fetchVouchers(); };
+  const refreshData = () => { fetchVouchers(); fetchProfile(); };
 
   return (
     <RewardsContext.Provider value={{
@@ -30,10 +30,10 @@ export default function AdminVouchers() {
         }
 
         if (editingId) {
-            // Edit Mode - Only updates local state via context currently (pending backend impl)
+            // Edit Mode
             const existing = availableVouchers.find(v => v.id === editingId);
             if (existing) {
-                editVoucher({
+                const success = await editVoucher({
                     ...existing,
                     title: voucherTitle,
                     discount: voucherDiscount,
@@ -41,7 +41,7 @@ export default function AdminVouchers() {
                     code: voucherCode || existing.code,
                     expiresAt: voucherExpiry || existing.expiresAt
                 });
-                alert("Đã cập nhật voucher (Lưu ý: Chức năng Edit chưa đồng bộ DB)!");
+                if (success) alert("Đã cập nhật voucher!");
             }
             setEditingId(null);
         } else {
@@ -1,4 +1,5 @@
 import { apiConfig } from '../config/aws';
+import type { UserRewardProfile } from '../types/rewards';
 
 export type DonateRequest = {
   kg: number;
@@ -10,10 +11,46 @@ export type DonateResponse = {
   pointsAdded: number;
 };
 
-export type ProfileResponse = {
+export type TierInfo = {
   name: string;
-  points: number;
-  history: Array<{ kg: number; points: number; createdAt: string }>;
+  multiplierPercent: number;
+  perks: string[];
+  nextTier?: string;
+  kgToNextTier?: number;
+};
+
+export type ProfileResponse = UserRewardProfile & {
+  pendingPoints: number;
+  expiringPoints: number;
+  expiringBefore: string;
+  nextExpiresAt?: string;
+  tier: TierInfo;
+  nextCursor?: string;
+};
+
+export type WalletStatus = 'active' | 'used' | 'expired' | 'void';
+
+export type WalletVoucher = {
+  id: string;
+  voucher_id: string;
+  code: string;
+  title: string;
+  discount: string;
+  points_required: number;
+  status: WalletStatus;
+  claimed_at: string;
+  expires_at?: string;
+  used_at?: string;
+  expired_at?: string;
+  voided_at?: string;
+  void_reason?: string;
+  order_ref?: string;
+  discount_vnd?: number;
+};
+
+export type WalletResponse = {
+  vouchers: WalletVoucher[];
+  next_cursor?: string;
 };
 
 const buildUrl = (path: string) => {
@@ -44,8 +81,25 @@ export const donatePlastic = (payload: DonateRequest, token?: string) =>
     headers: token ? { Authorization: `Bearer ${token}` } : undefined,
   });
 
-export const getProfile = (token: string) =>
-  request<ProfileResponse>('/profile', {
+export const getProfile = (token: string, cursor?: string) =>
+  request<ProfileResponse>(cursor ? `/profile?cursor=${encodeURIComponent(cursor)}` : '/profile', {
+    method: 'GET',
+    headers: { Authorization: `Bearer ${token}` },
+  });
+
+export const getWallet = (token: string, status?: WalletStatus, cursor?: string) => {
+  const params = new URLSearchParams();
+  if (status) params.set('status', status);
+  if (cursor) params.set('cursor', cursor);
+  const query = params.toString();
+  return request<WalletResponse>(query ? `/me/vouchers?${query}` : '/me/vouchers', {
+    method: 'GET',
+    headers: { Authorization: `Bearer ${token}` },
+  });
+};
+
+export const getWalletVoucher = (token: string, id: string) =>
+  request<WalletVoucher>(`/me/vouchers/${encodeURIComponent(id)}`, {
     method: 'GET',
     headers: { Authorization: `Bearer ${token}` },
   });
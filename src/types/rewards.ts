@@ -1,7 +1,7 @@
 export type RewardHistoryEntry = {
   id: string;
   userId?: string; // Optional for backward compatibility, but should be used
-  type: 'donate' | 'redeem' | 'admin_adjust';
+  type: 'donate' | 'redeem' | 'admin_adjust' | 'expire' | 'tier_change' | 'reversal';
   kg?: number;
   points: number;
   note: string;
@@ -14,9 +14,11 @@ export type Voucher = {
   title: string;
   code: string;
   discount: string;
+  category?: string;
   pointsRequired: number;
   expiresAt: string;
-  status: 'available' | 'claimed' | 'used';
+  status: 'available' | 'claimed' | 'used' | 'expired' | 'void';
+  version?: number;
 };
 
 export type RedeemOption = {